@@ -0,0 +1,183 @@
+package fastweb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// constraint 路径参数的约束，写在参数名之后：
+//
+//	/users/:id<[0-9]+>  正则约束，需要匹配整个参数值
+//	/users/:id:int      类型约束，见 paramTypes
+//
+// 参数值不满足约束时该路由不匹配
+type constraint struct {
+	expr  string // 路径中的约束表达式，如 <[0-9]+> 或 :int
+	match func(value string) bool
+}
+
+// paramTypes 支持的参数类型约束
+var paramTypes = map[string]func(string) bool{
+	"int": func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	},
+	"uint": func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err == nil
+	},
+	"float": func(s string) bool {
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	},
+	"alpha": func(s string) bool {
+		for i := 0; i < len(s); i++ {
+			if !isAlpha(s[i]) {
+				return false
+			}
+		}
+		return len(s) > 0
+	},
+	"alnum": func(s string) bool {
+		for i := 0; i < len(s); i++ {
+			if !isAlpha(s[i]) && !isDigit(s[i]) {
+				return false
+			}
+		}
+		return len(s) > 0
+	},
+	"uuid": regexp.MustCompile(
+		`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`,
+	).MatchString,
+}
+
+func isAlpha(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// 已编译的约束，同一个表达式只编译一次
+var constraints sync.Map
+
+// compileConstraint 编译约束表达式 expr（<regexp> 或 :type）
+func compileConstraint(expr string) (*constraint, error) {
+	if c, ok := constraints.Load(expr); ok {
+		return c.(*constraint), nil
+	}
+
+	c := &constraint{expr: expr}
+	switch expr[0] {
+	case '<':
+		re, err := regexp.Compile("^(?:" + expr[1:len(expr)-1] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid constraint '%s': %v", expr, err)
+		}
+		c.match = re.MatchString
+	case ':':
+		match, ok := paramTypes[expr[1:]]
+		if !ok {
+			return nil, fmt.Errorf("unknown param type '%s'", expr[1:])
+		}
+		c.match = match
+	default:
+		return nil, fmt.Errorf("invalid constraint '%s'", expr)
+	}
+
+	constraints.Store(expr, c)
+	return c, nil
+}
+
+// wildcardEnd 返回从 path[i]（':' 或 '*'）开始的通配符的结束位置，
+// 约束 <...> 不完整时返回 -1。
+// 参数名通常延伸到 '/'、约束或路径结尾，如 /u/:user-id 的参数名为 user-id。
+// 同一段中还有其他参数时，参数名只由字母、数字和 '_' 组成，
+// 之后的其他字符（如 '.'、'-'、' '）是分隔参数的字面量，如 /files/:name.:ext、/flights/:from-:to
+func wildcardEnd(path string, i int) int {
+	end := i + 1
+	if path[i] == '*' {
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
+		return end
+	}
+
+	for end < len(path) && isNameChar(path[end]) {
+		end++
+	}
+	if end == len(path) || end == i+1 {
+		return end
+	}
+	if c := path[end]; c != '/' && c != ':' && c != '<' && !paramFollows(path[end:]) {
+		// a single param in the segment, its name runs to the end of the
+		// segment or to its constraint
+		for end < len(path) && path[end] != '/' && path[end] != ':' && path[end] != '<' && path[end] != '*' {
+			end++
+		}
+		if end == len(path) {
+			return end
+		}
+	}
+
+	switch path[end] {
+	case ':':
+		// typed constraint, e.g. :id:int
+		j := end + 1
+		for j < len(path) && isNameChar(path[j]) {
+			j++
+		}
+		if j > end+1 {
+			return j
+		}
+	case '<':
+		// regexp constraint, brackets may be nested
+		depth := 0
+		for ; end < len(path); end++ {
+			switch path[end] {
+			case '<':
+				depth++
+			case '>':
+				depth--
+			}
+			if depth == 0 {
+				return end + 1
+			}
+		}
+		return -1
+	}
+	return end
+}
+
+// paramFollows 判断 literal 所在的路径段中是否还有参数，即 literal 中是否有前一个字符不属于参数名的 ':'，
+// 跟在参数名之后的 ':' 是类型约束，'<' 之后是正则约束
+func paramFollows(literal string) bool {
+	for j := 1; j < len(literal); j++ {
+		switch literal[j] {
+		case '/', '<':
+			return false
+		case ':':
+			if !isNameChar(literal[j-1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isNameChar(c byte) bool {
+	return isAlpha(c) || isDigit(c) || c == '_'
+}
+
+// splitWildcard 把通配符拆分为名称和约束表达式，如 :id<[0-9]+> 拆分为 id 和 <[0-9]+>
+func splitWildcard(wildcard string) (name, expr string) {
+	for i := 1; i < len(wildcard); i++ {
+		if c := wildcard[i]; c == '<' || c == ':' {
+			return wildcard[1:i], wildcard[i:]
+		}
+	}
+	return wildcard[1:], ""
+}
@@ -3,7 +3,11 @@ package fastweb
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"mime/multipart"
+	"strings"
 	"sync"
 
 	"github.com/valyala/fasthttp"
@@ -41,8 +45,9 @@ type Context interface {
 	Host() string
 	// QueryArgs() *fasthttp.Args
 	// PostArgs() *fasthttp.Args
-	// MultipartForm() (*multipart.Form, error)
-	// FormFile(key string) (*multipart.FileHeader, error)
+	MultipartForm() (*multipart.Form, error)
+	FormFile(key string) (*multipart.FileHeader, error)
+	SaveUploadedFile(fh *multipart.FileHeader, dst string) error
 	// FormValue(key string) []byte
 	SetURLParam(Params)
 	URLParam(string) (string, bool)
@@ -51,6 +56,9 @@ type Context interface {
 	FormValues() map[string]string
 	QueryParam(string) (string, bool)
 	QueryParams(interface{}) error
+	BindJSON(interface{}) error   // 解码 JSON 请求体并按 valid 标签校验
+	Bind(interface{}) error       // 按 path、query、header、cookie、form 标签绑定并按 valid 标签校验
+	ShouldBind(interface{}) error // 按 Content-Type 选择解码方式绑定并按 valid 标签校验
 	// IsGet() bool
 	// IsPost() bool
 	// IsPut() bool
@@ -91,13 +99,25 @@ type Context interface {
 
 	SetBodyStrf(int, string, ...interface{})
 	JSON(int, interface{})
+
+	Next()                    // 执行处理链中剩余的处理器（中间件中使用）
+	Abort()                   // 中止处理链，之后的处理器不再执行
+	AbortWithStatus(code int) // 设置状态码并中止处理链
+	IsAborted() bool          // 处理链是否已被中止
+
+	URLFor(name string, params ...string) (string, error) // 根据路由名称生成 URL
+	Route() *Route                                        // 匹配的路由，未匹配到路由时为 nil
 }
 
 var _ Context = (*context)(nil)
 
 type context struct {
-	fctx      *fasthttp.RequestCtx
-	urlParams map[string]string
+	fctx     *fasthttp.RequestCtx
+	params   Params // Host 参数和路径参数，随 context 一起复用
+	handlers HandlersChain
+	index    int8
+	engine   *Engine
+	route    *Route
 }
 
 var ctxPool *sync.Pool = &sync.Pool{
@@ -108,7 +128,8 @@ var ctxPool *sync.Pool = &sync.Pool{
 
 func (c *context) Init(ctx *fasthttp.RequestCtx) {
 	c.fctx = ctx
-	c.urlParams = make(map[string]string)
+	c.params = c.params[:0]
+	c.index = -1
 }
 
 func (c *context) GetFctx() *fasthttp.RequestCtx {
@@ -123,15 +144,54 @@ func (c *context) GetFctx() *fasthttp.RequestCtx {
 // }
 
 func (c *context) releaseCtx() {
-	c.urlParams = nil
+	c.params = c.params[:0]
 	c.fctx = nil
+	c.handlers = nil
+	c.index = -1
+	c.engine = nil
+	c.route = nil
 	ctxPool.Put(c)
 }
 
-func (c *context) SetURLParam(ps Params) {
-	for _, param := range ps {
-		c.urlParams[param.Key] = param.Value
+// Next 依次执行处理链中剩余的处理器，中间件调用 Next 之后的代码会在后续处理器返回后执行
+func (c *context) Next() {
+	c.index++
+	for c.index < int8(len(c.handlers)) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// Abort 中止处理链，当前处理器返回后不再执行后续处理器
+func (c *context) Abort() {
+	c.index = abortIndex
+}
+
+// AbortWithStatus 设置响应状态码并中止处理链
+func (c *context) AbortWithStatus(code int) {
+	c.fctx.SetStatusCode(code)
+	c.Abort()
+}
+
+// IsAborted 处理链是否已被中止
+func (c *context) IsAborted() bool {
+	return c.index >= abortIndex
+}
+
+// paramsFor 返回容量足够存放 root 中路由参数的 c.params，容量不足时扩容，
+// 扩容后的切片随 context 复用，之后的请求不再分配内存
+func (c *context) paramsFor(root *node) Params {
+	if need := len(c.params) + int(root.maxParams); cap(c.params) < need {
+		ps := make(Params, len(c.params), need)
+		copy(ps, c.params)
+		c.params = ps
 	}
+	return c.params
+}
+
+// SetURLParam 添加参数，同名参数以后添加的为准
+func (c *context) SetURLParam(ps Params) {
+	c.params = append(c.params, ps...)
 }
 
 func (c *context) SetUserValue(key string, value interface{}) {
@@ -222,12 +282,23 @@ func (c *context) Logger() fasthttp.Logger {
 	return c.fctx.Logger()
 }
 
+// URLParam 返回 Host 参数或路径参数，同名时路径参数优先
 func (c *context) URLParam(key string) (string, bool) {
-	val, ok := c.urlParams[key]
-	return val, ok
+	for i := len(c.params) - 1; i >= 0; i-- {
+		if c.params[i].Key == key {
+			return c.params[i].Value, true
+		}
+	}
+	return "", false
 }
+
+// URLParams 返回所有参数，每次调用都会创建新的 map，热路径中应使用 URLParam
 func (c *context) URLParams() map[string]string {
-	return c.urlParams
+	params := make(map[string]string, len(c.params))
+	for _, param := range c.params {
+		params[param.Key] = param.Value
+	}
+	return params
 }
 
 func (c *context) FormValue(key string) (string, bool) {
@@ -271,6 +342,118 @@ func (c *context) QueryParams(obj interface{}) error {
 	return err
 }
 
+// BindJSON 把 JSON 请求体解码到 obj，然后按 valid 标签中的 required、strip、minlength、
+// maxlength、re 规则校验，错误信息中使用 JSON 字段名
+func (c *context) BindJSON(obj interface{}) error {
+	ps, err := scan(obj)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(c.fctx.PostBody(), obj); err != nil {
+		return err
+	}
+	return ps.validJSON(obj, c.fctx.PostBody())
+}
+
+// Bind 按字段的 path、query、header、cookie、form 标签从路径参数、查询参数、请求头、
+// Cookie 或表单中取值，如 `path:"id" valid:",required"`。标签值为空时使用 valid 标签中的名称，赋值和校验使用 valid 标签的规则。
+// 一个字段有多个来源标签时只使用第一个，没有来源标签的字段不做处理
+func (c *context) Bind(obj interface{}) error {
+	ps, err := scan(obj)
+	if err != nil {
+		return err
+	}
+	return ps.bind(obj, c)
+}
+
+// MultipartForm 返回请求的 multipart 表单。有 Content-Length 的请求在读取时已由 fasthttp 解析，
+// 其中超过 16MB 的文件暂存到临时文件，请求结束后删除；其它请求在内存中解析已读取的请求体
+func (c *context) MultipartForm() (*multipart.Form, error) {
+	return c.fctx.MultipartForm()
+}
+
+// FormFile 返回 multipart 表单中名为 key 的第一个文件
+func (c *context) FormFile(key string) (*multipart.FileHeader, error) {
+	return c.fctx.FormFile(key)
+}
+
+// SaveUploadedFile 把上传的文件保存到 dst，暂存在磁盘上的文件会直接移动到 dst
+func (c *context) SaveUploadedFile(fh *multipart.FileHeader, dst string) error {
+	return fasthttp.SaveMultipartFile(fh, dst)
+}
+
+// ErrUnsupportedMediaType ShouldBind 不支持请求的 Content-Type，可以返回 415 状态码
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// ShouldBind 按请求的 Content-Type 选择解码方式为 obj 赋值并按 valid 标签校验：
+// application/x-www-form-urlencoded 和 multipart/form-data 从表单中取值，
+// 有 form 标签的字段使用标签中的名称（与 Bind 相同），其他字段使用 valid 标签中的名称；
+// application/json 同 BindJSON，application/xml 和 text/xml 解码 XML 后校验，错误信息中使用 XML 元素名。
+// 其他 Content-Type 返回 ErrUnsupportedMediaType
+func (c *context) ShouldBind(obj interface{}) error {
+	ps, err := scan(obj)
+	if err != nil {
+		return err
+	}
+
+	switch contentType(c.fctx.Request.Header.ContentType()) {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return ps.bindForm(obj, c)
+	case "application/json":
+		if err := json.Unmarshal(c.fctx.PostBody(), obj); err != nil {
+			return err
+		}
+		return ps.validJSON(obj, c.fctx.PostBody())
+	case "application/xml", "text/xml":
+		if err := xml.Unmarshal(c.fctx.PostBody(), obj); err != nil {
+			return err
+		}
+		return ps.validXML(obj, c.fctx.PostBody())
+	}
+	return ErrUnsupportedMediaType
+}
+
+// contentType 返回去掉参数并转为小写的媒体类型，如 "application/json; charset=utf-8" 返回 "application/json"
+func contentType(v []byte) string {
+	if i := bytes.IndexByte(v, ';'); i >= 0 {
+		v = v[:i]
+	}
+	return strings.ToLower(string(bytes.TrimSpace(v)))
+}
+
+// files 返回 multipart 表单中名为 key 的上传文件
+func (c *context) files(key string) []*multipart.FileHeader {
+	form, err := c.fctx.MultipartForm()
+	if err != nil {
+		return nil
+	}
+	return form.File[key]
+}
+
+// lookup 返回 key 在 source 中的值
+func (c *context) lookup(source int, key string) []byte {
+	switch source {
+	case sourcePath:
+		v, _ := c.URLParam(key)
+		return s2b(v)
+	case sourceQuery:
+		return c.fctx.QueryArgs().Peek(key)
+	case sourceHeader:
+		return c.fctx.Request.Header.Peek(key)
+	case sourceCookie:
+		return c.fctx.Request.Header.Cookie(key)
+	case sourceForm:
+		if v := c.fctx.PostArgs().Peek(key); len(v) > 0 {
+			return v
+		}
+		if form, err := c.fctx.MultipartForm(); err == nil && len(form.Value[key]) > 0 {
+			return s2b(form.Value[key][0])
+		}
+	}
+	return nil
+}
+
 func (c *context) SetStatus(code int) {
 	c.fctx.Response.SetStatusCode(code)
 }
@@ -306,3 +489,13 @@ func (c *context) HTML(code int, html string) {
 	c.SetStatus(code)
 	fmt.Fprint(c.fctx, html)
 }
+
+// URLFor 根据路由名称和参数生成 URL，参见 Engine.URL
+func (c *context) URLFor(name string, params ...string) (string, error) {
+	return c.engine.URL(name, params...)
+}
+
+// Route 返回匹配的路由，可以在中间件中读取路由模式和元数据，如 ctx.Route().Path 为 /users/:id
+func (c *context) Route() *Route {
+	return c.route
+}
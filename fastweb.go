@@ -1,18 +1,35 @@
 package fastweb
 
 import (
+	"math"
+	"os"
+	"strings"
+	"sync"
+
 	"github.com/valyala/fasthttp"
 )
 
 // HandlerFunc 定义 http 处理器
 type HandlerFunc func(ctx Context)
 
+// HandlersChain 处理器链，中间件在前，路由处理器在最后
+type HandlersChain []HandlerFunc
+
+// abortIndex 处理链被中止时 context.index 的取值，同时也限制了处理链的最大长度
+const abortIndex int8 = math.MaxInt8 / 2
+
 // Engine fastweb 引擎
 type Engine struct {
 	*RouterGroup
 	router *Router
 	groups []*RouterGroup
+	hosts  []*hostRouter
 	logger fasthttp.Logger
+	debug  bool
+
+	// mu 保护 groups 和 namedRoutes，使服务运行时也可以注册分组和路由
+	mu          sync.RWMutex
+	namedRoutes map[string]*Route
 }
 
 // RouterGroup 路由分组结构体
@@ -21,108 +38,246 @@ type RouterGroup struct {
 	middlewares []HandlerFunc
 	parent      *RouterGroup
 	engine      *Engine
+	router      *Router // 分组路由注册到的路由树，Host 分组使用独立的路由树
+	host        string
+
+	notFound         HandlerFunc
+	methodNotAllowed HandlerFunc
+	panicHandler     HandlerFunc
+	redirectPolicy   RedirectPolicy
+	caseMode         caseMode
 }
 
+// caseMode 分组路由的大小写匹配模式
+type caseMode uint8
+
+const (
+	caseInherit     caseMode = iota // 使用父分组或者路由的设置
+	caseSensitive                   // 区分大小写
+	caseInsensitive                 // 不区分大小写
+)
+
 // New 返回 *Engine 实例
-func New() *Engine {
+func New(options ...engineOption) *Engine {
 	engine := &Engine{router: newRouter()}
-	engine.RouterGroup = &RouterGroup{engine: engine}
+	engine.RouterGroup = &RouterGroup{engine: engine, router: engine.router}
 	engine.groups = []*RouterGroup{engine.RouterGroup}
+	engine.router.addGroup(engine.RouterGroup)
+
+	for _, f := range options {
+		f(engine)
+	}
 	return engine
 }
 
-// addRoute 向根 group 添加路由
-func (engine *Engine) addRoute(method string, pattern string, handle HandlerFunc) {
-	engine.router.addRoute(method, pattern, handle)
+// Use 添加全局中间件，对之后注册的所有路由生效
+func (engine *Engine) Use(middlewares ...HandlerFunc) *RouterGroup {
+	return engine.RouterGroup.Use(middlewares...)
 }
 
-func (engine *Engine) GET(pattern string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodGet, pattern, handle)
+// Group 创建分组路由
+func (group *RouterGroup) Group(prefix string) *RouterGroup {
+	engine := group.engine
+	newGroup := &RouterGroup{
+		prefix: group.prefix + prefix,
+		parent: group,
+		engine: engine,
+		router: group.router,
+		host:   group.host,
+	}
+	engine.mu.Lock()
+	engine.groups = append(engine.groups, newGroup)
+	engine.mu.Unlock()
+	newGroup.router.addGroup(newGroup)
+	return newGroup
 }
 
-func (engine *Engine) HEAD(path string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodHead, path, handle)
+// Use 向当前分组添加中间件，子分组会继承父分组的中间件。
+// 处理链在注册路由时生成，所以中间件只对之后注册的路由生效
+func (group *RouterGroup) Use(middlewares ...HandlerFunc) *RouterGroup {
+	group.middlewares = append(group.middlewares, middlewares...)
+	return group
 }
 
-func (engine *Engine) OPTIONS(path string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodOptions, path, handle)
+// NotFound 设置当前分组的 404 处理器，请求路径匹配多个分组时使用前缀最长的分组，
+// 分组没有设置时依次使用父分组的处理器。在 Engine 上调用对所有路由生效
+func (group *RouterGroup) NotFound(handler HandlerFunc) *RouterGroup {
+	group.notFound = handler
+	return group
 }
 
-func (engine *Engine) POST(pattern string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodPost, pattern, handle)
+// MethodNotAllowed 设置当前分组的 405 处理器，调用前已设置 Allow 响应头，分组的匹配规则同 NotFound
+func (group *RouterGroup) MethodNotAllowed(handler HandlerFunc) *RouterGroup {
+	group.methodNotAllowed = handler
+	return group
 }
 
-func (engine *Engine) PUT(path string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodPut, path, handle)
+// PanicHandler 设置当前分组的 panic 处理器，panic 的值可以通过 UserValue("PanicError") 获取，
+// 分组的匹配规则同 NotFound
+func (group *RouterGroup) PanicHandler(handler HandlerFunc) *RouterGroup {
+	group.panicHandler = handler
+	return group
 }
 
-func (engine *Engine) PATCH(path string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodPatch, path, handle)
+// RedirectPolicy 设置当前分组的路径修正策略：重定向（默认）、内部重写或者不修正（404），
+// 对尾部斜杠（RedirectTrailingSlash）和路径修正（RedirectFixedPath）都生效。
+// 按修正后的路径匹配分组，分组的匹配规则同 NotFound，PolicyInherit 表示使用父分组的策略
+func (group *RouterGroup) RedirectPolicy(policy RedirectPolicy) *RouterGroup {
+	group.redirectPolicy = policy
+	return group
 }
 
-func (engine *Engine) DELETE(path string, handle HandlerFunc) {
-	engine.addRoute(fasthttp.MethodDelete, path, handle)
+// CaseInsensitive 设置当前分组的路由是否不区分大小写匹配，匹配后直接调用处理器而不是重定向，
+// 路径参数保留请求中的大小写。分组前缀不区分大小写，匹配规则同 NotFound
+func (group *RouterGroup) CaseInsensitive(enabled bool) *RouterGroup {
+	if enabled {
+		group.caseMode = caseInsensitive
+	} else {
+		group.caseMode = caseSensitive
+	}
+	return group
 }
 
-func (engine *Engine) ServeFiles(path, root string) {
-	engine.router.ServeFiles(path, root)
-}
+// combineHandlers 按 根分组 -> 当前分组 的顺序合并中间件，并把 handlers 放在最后
+func (group *RouterGroup) combineHandlers(handlers ...HandlerFunc) HandlersChain {
+	size := len(handlers)
+	for g := group; g != nil; g = g.parent {
+		size += len(g.middlewares)
+	}
+	if size >= int(abortIndex) {
+		panic("too many handlers")
+	}
 
-// Group 创建分组路由
-func (group *RouterGroup) Group(prefix string) *RouterGroup {
-	engine := group.engine
-	newGroup := &RouterGroup{
-		prefix: group.prefix + prefix,
-		parent: group,
-		engine: engine,
+	chain := make(HandlersChain, size)
+	i := size - len(handlers)
+	copy(chain[i:], handlers)
+	for g := group; g != nil; g = g.parent {
+		i -= len(g.middlewares)
+		copy(chain[i:], g.middlewares)
 	}
-	engine.groups = append(engine.groups, newGroup)
-	return newGroup
+	return chain
 }
 
-// 向当前分组路由中添加路由
-func (group *RouterGroup) addRoute(method, comp string, handler HandlerFunc) {
+// 向当前分组路由中添加路由，handlers 中最后一个为路由处理器，其余的为该路由独有的中间件
+func (group *RouterGroup) addRoute(method, comp string, handlers HandlersChain) *Route {
 	pattern := group.prefix + comp
+	if len(handlers) == 0 {
+		panic("there must be at least one handler in path '" + pattern + "'")
+	}
 	// log.Printf("Route %4s - %s", method, pattern)
-	group.engine.router.addRoute(method, pattern, handler)
+	route := &Route{
+		Method: method,
+		Path:   pattern,
+		Host:   group.host,
+		Prefix: group.prefix,
+		engine: group.engine,
+		router: group.router,
+	}
+	group.router.addRoute(method, pattern, group.combineHandlers(handlers...), route.snapshot())
+	return route
+}
+
+// RemoveRoute 删除当前分组中注册的路由，path 为注册时使用的路径（不含分组前缀），
+// 路由的名称也会被删除，返回路由是否存在。
+// 路由树采用写时复制，服务运行时可以安全地注册和删除路由
+func (group *RouterGroup) RemoveRoute(method, path string) bool {
+	pattern := group.prefix + path
+	if !group.router.removeRoute(method, pattern) {
+		return false
+	}
+
+	engine := group.engine
+	engine.mu.Lock()
+	for name, route := range engine.namedRoutes {
+		if route.Method == method && route.Path == pattern && route.Host == group.host {
+			delete(engine.namedRoutes, name)
+		}
+	}
+	engine.mu.Unlock()
+	return true
 }
 
-func (group *RouterGroup) GET(pattern string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodGet, pattern, handle)
+func (group *RouterGroup) GET(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodGet, pattern, handlers)
 }
 
-func (group *RouterGroup) HEAD(path string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodHead, path, handle)
+func (group *RouterGroup) HEAD(path string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodHead, path, handlers)
 }
 
-func (group *RouterGroup) OPTIONS(path string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodOptions, path, handle)
+func (group *RouterGroup) OPTIONS(path string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodOptions, path, handlers)
 }
 
-func (group *RouterGroup) POST(pattern string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodPost, pattern, handle)
+func (group *RouterGroup) POST(pattern string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodPost, pattern, handlers)
 }
 
-func (group *RouterGroup) PUT(path string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodPut, path, handle)
+func (group *RouterGroup) PUT(path string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodPut, path, handlers)
 }
 
-func (group *RouterGroup) PATCH(path string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodPatch, path, handle)
+func (group *RouterGroup) PATCH(path string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodPatch, path, handlers)
 }
 
-func (group *RouterGroup) DELETE(path string, handle HandlerFunc) {
-	group.addRoute(fasthttp.MethodDelete, path, handle)
+func (group *RouterGroup) DELETE(path string, handlers ...HandlerFunc) *Route {
+	return group.addRoute(fasthttp.MethodDelete, path, handlers)
 }
 
+// Handle 注册任意请求方法的路由，可用于 PROPFIND、MKCOL、PURGE 等自定义方法
+func (group *RouterGroup) Handle(method, path string, handlers ...HandlerFunc) *Route {
+	if !validMethod(method) {
+		panic("http method '" + method + "' is not valid")
+	}
+	return group.addRoute(method, path, handlers)
+}
+
+// anyMethods Any 注册的标准请求方法
+var anyMethods = []string{
+	fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodPost,
+	fasthttp.MethodPut, fasthttp.MethodPatch, fasthttp.MethodDelete,
+	fasthttp.MethodConnect, fasthttp.MethodOptions, fasthttp.MethodTrace,
+}
+
+// Any 为 path 注册所有标准请求方法的路由，返回的路由与 anyMethods 顺序一致
+func (group *RouterGroup) Any(path string, handlers ...HandlerFunc) []*Route {
+	routes := make([]*Route, len(anyMethods))
+	for i, method := range anyMethods {
+		routes[i] = group.addRoute(method, path, handlers)
+	}
+	return routes
+}
+
+// validMethod 请求方法必须是 RFC 7230 中定义的 token
+func validMethod(method string) bool {
+	if len(method) == 0 {
+		return false
+	}
+	for i := 0; i < len(method); i++ {
+		c := method[i]
+		if isAlpha(c) || isDigit(c) {
+			continue
+		}
+		if strings.IndexByte("!#$%&'*+-.^_`|~", c) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeFiles 以 root 为根目录提供静态文件服务，path 必须以 "/*filepath" 结尾
 func (group *RouterGroup) ServeFiles(path, root string) {
-	group.engine.router.ServeFiles(path, root)
+	group.GET(path, fileHandler(group.prefix+path, root))
 }
 
 func (engine *Engine) requestHandler(fctx *fasthttp.RequestCtx) {
 	ctx := ctxPool.Get().(*context)
 	ctx.Init(fctx)
-	engine.router.Handle(ctx)
+	ctx.engine = engine
+	var router *Router
+	router, ctx.params = engine.matchHost(b2s(fctx.Host()), ctx.params)
+	router.Handle(ctx)
 	ctx.releaseCtx()
 }
 
@@ -138,5 +293,9 @@ func (engine *Engine) Run(addr string, options ...svrOption) (err error) {
 		f(server)
 	}
 
+	if engine.debug {
+		printRoutes(os.Stdout, engine.Routes())
+	}
+
 	return server.ListenAndServe(addr) // fasthttp.ListenAndServe(addr, engine.requestHandler)
 }
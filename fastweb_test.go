@@ -0,0 +1,263 @@
+package fastweb
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func performRequest(engine *Engine, method, uri string) *fasthttp.RequestCtx {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(method)
+	fctx.Request.SetRequestURI(uri)
+	engine.requestHandler(fctx)
+	return fctx
+}
+
+func TestMiddleware(t *testing.T) {
+	var trace string
+	mark := func(s string) HandlerFunc {
+		return func(ctx Context) {
+			trace += s
+			ctx.Next()
+			trace += s
+		}
+	}
+
+	engine := New()
+	engine.Use(mark("a"))
+	group := engine.Group("/api")
+	group.Use(mark("b"))
+	sub := group.Group("/v1")
+	sub.Use(mark("c"))
+	sub.GET("/ping", func(ctx Context) {
+		trace += "h"
+	})
+	engine.GET("/root", func(ctx Context) {
+		trace += "r"
+	})
+
+	performRequest(engine, "GET", "/api/v1/ping")
+	if trace != "abchcba" {
+		t.Fatalf("wrong middleware order: %q", trace)
+	}
+
+	trace = ""
+	performRequest(engine, "GET", "/root")
+	if trace != "ara" {
+		t.Fatalf("wrong middleware order: %q", trace)
+	}
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	var called bool
+	engine := New()
+	engine.Use(func(ctx Context) {
+		ctx.AbortWithStatus(fasthttp.StatusUnauthorized)
+	}, func(ctx Context) {
+		if !ctx.IsAborted() {
+			t.Error("middleware after Abort was called")
+		}
+	})
+	engine.GET("/secret", func(ctx Context) {
+		called = true
+	})
+
+	fctx := performRequest(engine, "GET", "/secret")
+	if called {
+		t.Fatal("handler was called after Abort")
+	}
+	if code := fctx.Response.StatusCode(); code != fasthttp.StatusUnauthorized {
+		t.Fatalf("wrong status code: %d", code)
+	}
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	var trace string
+	mark := func(s string) HandlerFunc {
+		return func(ctx Context) {
+			trace += s
+		}
+	}
+
+	engine := New()
+	group := engine.Group("/orders")
+	group.Use(mark("g"))
+	group.POST("/", mark("a"), mark("b"), mark("h"))
+	group.GET("/", mark("h"))
+
+	performRequest(engine, "POST", "/orders/")
+	if trace != "gabh" {
+		t.Fatalf("wrong handlers order: %q", trace)
+	}
+
+	trace = ""
+	performRequest(engine, "GET", "/orders/")
+	if trace != "gh" {
+		t.Fatalf("route middleware leaked into another route: %q", trace)
+	}
+}
+
+func TestHost(t *testing.T) {
+	engine := New()
+	engine.GET("/", func(ctx Context) {
+		ctx.SetBodyStrf(200, "%s", "default")
+	})
+	engine.Host("api.example.com").GET("/", func(ctx Context) {
+		ctx.SetBodyStrf(200, "%s", "api")
+	})
+	tenant := engine.Host(":tenant.example.com")
+	tenant.GET("/users/:id", func(ctx Context) {
+		tenant, _ := ctx.URLParam("tenant")
+		id, _ := ctx.URLParam("id")
+		ctx.SetBodyStrf(200, "%s", tenant+":"+id)
+	})
+	if engine.Host(":tenant.example.com") != tenant {
+		t.Error("Host returned a new group for a registered pattern")
+	}
+
+	tests := []struct {
+		uri  string
+		code int
+		body string
+	}{
+		{"http://api.example.com/", 200, "api"},
+		{"http://API.example.com:8080/", 200, "api"},
+		{"http://acme.example.com/users/1", 200, "acme:1"},
+		{"http://acme.example.com/", 404, ""},
+		{"http://example.com/", 200, "default"},
+		{"http://a.b.example.com/", 200, "default"},
+		{"http://localhost/", 200, "default"},
+	}
+	for _, tt := range tests {
+		fctx := performRequest(engine, "GET", tt.uri)
+		if fctx.Response.StatusCode() != tt.code {
+			t.Errorf("%s: got status %d, want %d", tt.uri, fctx.Response.StatusCode(), tt.code)
+			continue
+		}
+		if tt.body != "" && string(fctx.Response.Body()) != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.uri, fctx.Response.Body(), tt.body)
+		}
+	}
+}
+
+func TestHandleCustomMethod(t *testing.T) {
+	var method string
+	handler := func(ctx Context) {
+		method = ctx.Method()
+	}
+
+	engine := New()
+	engine.Handle("PROPFIND", "/dav/*path", handler)
+	engine.Handle("MKCOL", "/dav/*path", handler)
+	engine.GET("/dav/*path", handler)
+
+	performRequest(engine, "PROPFIND", "/dav/a/b")
+	if method != "PROPFIND" {
+		t.Errorf("got method %q, want PROPFIND", method)
+	}
+
+	if allow := engine.router.allowed("/dav/a", "PURGE"); allow != "GET, HEAD, MKCOL, OPTIONS, PROPFIND" {
+		t.Errorf("got Allow %q", allow)
+	}
+	if allow := engine.router.allowed("*", "OPTIONS"); allow != "GET, HEAD, MKCOL, OPTIONS, PROPFIND" {
+		t.Errorf("got global Allow %q", allow)
+	}
+
+	for _, m := range []string{"", "GET POST", "GE/T"} {
+		if recv := catchPanic(func() { engine.Handle(m, "/x", handler) }); recv == nil {
+			t.Errorf("no panic for invalid method %q", m)
+		}
+	}
+}
+
+func TestAny(t *testing.T) {
+	var count int
+	engine := New()
+	routes := engine.Any("/any", func(ctx Context) {
+		count++
+	})
+	if len(routes) != len(anyMethods) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(anyMethods))
+	}
+
+	for _, method := range anyMethods {
+		performRequest(engine, method, "/any")
+	}
+	if count != len(anyMethods) {
+		t.Errorf("handler called %d times, want %d", count, len(anyMethods))
+	}
+}
+
+func TestGroupErrorHandlers(t *testing.T) {
+	reply := func(body string) HandlerFunc {
+		return func(ctx Context) {
+			ctx.SetBodyStrf(418, "%s", body)
+		}
+	}
+	handler := func(ctx Context) {}
+
+	engine := New(WithNotFound(reply("engine")), WithPanicHandler(reply("engine panic")))
+	api := engine.Group("/api")
+	api.NotFound(reply("api")).MethodNotAllowed(reply("api 405")).PanicHandler(reply("api panic"))
+	v1 := api.Group("/v1")
+	v1.GET("/users", handler)
+	v1.GET("/panic", func(ctx Context) {
+		panic("boom")
+	})
+	web := engine.Group("/web")
+	web.NotFound(reply("web"))
+	web.GET("/panic", func(ctx Context) {
+		panic("boom")
+	})
+
+	tests := []struct {
+		method, uri string
+		body        string
+	}{
+		{"GET", "/api/missing", "api"},
+		{"GET", "/api/v1/missing", "api"},
+		{"POST", "/api/v1/users", "api 405"},
+		{"GET", "/api/v1/panic", "api panic"},
+		{"GET", "/web/missing", "web"},
+		{"GET", "/web/panic", "engine panic"},
+		{"GET", "/apis", "engine"},
+		{"GET", "/missing", "engine"},
+	}
+	for _, tt := range tests {
+		fctx := performRequest(engine, tt.method, tt.uri)
+		if body := string(fctx.Response.Body()); body != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.method, tt.uri, body, tt.body)
+		}
+	}
+
+	fctx := performRequest(engine, "POST", "/api/v1/users")
+	if allow := string(fctx.Response.Header.Peek("Allow")); allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("got Allow %q", allow)
+	}
+
+	// the root group handlers apply to host routes as well
+	engine.NotFound(reply("root"))
+	engine.Host("api.example.com").GET("/", handler)
+	fctx = performRequest(engine, "GET", "http://api.example.com/missing")
+	if body := string(fctx.Response.Body()); body != "root" {
+		t.Errorf("got body %q for host route, want %q", body, "root")
+	}
+}
+
+func TestRouterOptions(t *testing.T) {
+	handler := func(ctx Context) {}
+	engine := New(WithRedirectTrailingSlash(false), WithRedirectFixedPath(false), WithHandleMethodNotAllowed(false))
+	engine.GET("/path", handler)
+	host := engine.Host("api.example.com")
+	host.GET("/path", handler)
+
+	for _, uri := range []string{"/path/", "/PATH", "http://api.example.com/path/"} {
+		if code := performRequest(engine, "GET", uri).Response.StatusCode(); code != 404 {
+			t.Errorf("GET %s: got status %d, want 404", uri, code)
+		}
+	}
+	if code := performRequest(engine, "POST", "/path").Response.StatusCode(); code != 404 {
+		t.Errorf("POST /path: got status %d, want 404", code)
+	}
+}
@@ -0,0 +1,127 @@
+package fastweb
+
+import (
+	"strings"
+)
+
+// hostRouter 按请求 Host 匹配的路由，每个 Host 拥有独立的路由树
+type hostRouter struct {
+	pattern string
+	labels  []string // pattern 按 '.' 切分后的各段，以 ':' 开头的段为参数
+	params  int      // 参数段的数量
+	router  *Router
+	group   *RouterGroup
+}
+
+// Host 返回匹配 pattern 的路由分组，分组下的路由注册在该 Host 独立的路由树中。
+// pattern 按 '.' 分段，以 ':' 开头的段为参数，如 ":tenant.example.com"，
+// 参数值和路径参数一样通过 URLParam 读取。
+// 不含参数的 pattern 优先匹配；请求的 Host 不匹配任何 pattern 时使用默认的路由树
+// Host 的路由树复制默认路由树的配置（WithNotFound、WithRedirectTrailingSlash 等）。
+// Host 需要在服务启动前调用，之后可以随时向返回的分组中注册路由
+func (engine *Engine) Host(pattern string) *RouterGroup {
+	for _, h := range engine.hosts {
+		if strings.EqualFold(h.pattern, pattern) {
+			return h.group
+		}
+	}
+
+	h := &hostRouter{
+		pattern: pattern,
+		labels:  strings.Split(pattern, "."),
+		router:  engine.router.clone(),
+	}
+	for _, label := range h.labels {
+		if len(label) == 0 {
+			panic("empty label in host '" + pattern + "'")
+		}
+		if label[0] != ':' {
+			continue
+		}
+		if len(label) < 2 || strings.IndexFunc(label[1:], func(r rune) bool {
+			return r > 0x7f || !isNameChar(byte(r))
+		}) >= 0 {
+			panic("invalid param '" + label + "' in host '" + pattern + "'")
+		}
+		h.params++
+	}
+
+	h.group = &RouterGroup{
+		parent: engine.RouterGroup,
+		engine: engine,
+		router: h.router,
+		host:   pattern,
+	}
+	engine.mu.Lock()
+	engine.groups = append(engine.groups, h.group)
+	engine.mu.Unlock()
+	h.router.addGroup(h.group)
+	engine.hosts = append(engine.hosts, h)
+	return h.group
+}
+
+// match 判断 host 是否匹配，匹配时返回追加了 Host 参数的 ps
+func (h *hostRouter) match(host string, ps Params) (bool, Params) {
+	for i, label := range h.labels {
+		var part string
+		if i == len(h.labels)-1 {
+			part = host
+		} else {
+			end := strings.IndexByte(host, '.')
+			if end < 0 {
+				return false, nil
+			}
+			part, host = host[:end], host[end+1:]
+		}
+
+		if label[0] == ':' {
+			if len(part) == 0 {
+				return false, nil
+			}
+			ps = append(ps, Param{Key: label[1:], Value: part})
+		} else if !strings.EqualFold(label, part) {
+			return false, nil
+		}
+	}
+	return true, ps
+}
+
+// matchHost 返回处理 host 的路由，Host 参数追加到 ps 中。
+// 没有匹配的 Host 时返回默认路由
+func (engine *Engine) matchHost(host string, ps Params) (*Router, Params) {
+	if len(engine.hosts) == 0 {
+		return engine.router, ps
+	}
+
+	host = stripPort(host)
+	// hosts without params first
+	for _, h := range engine.hosts {
+		if h.params == 0 {
+			if ok, _ := h.match(host, nil); ok {
+				return h.router, ps
+			}
+		}
+	}
+	for _, h := range engine.hosts {
+		if h.params > 0 {
+			if ok, hostPs := h.match(host, ps); ok {
+				return h.router, hostPs
+			}
+		}
+	}
+	return engine.router, ps
+}
+
+// stripPort 去掉 host 中的端口号，支持 [::1]:8080 形式的 IPv6 地址
+func stripPort(host string) string {
+	if len(host) > 0 && host[0] == '[' {
+		if end := strings.IndexByte(host, ']'); end > 0 {
+			return host[1:end]
+		}
+		return host
+	}
+	if i := strings.LastIndexByte(host, ':'); i >= 0 {
+		return host[:i]
+	}
+	return host
+}
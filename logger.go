@@ -1,5 +1,12 @@
 package fastweb
 
+import (
+	"fmt"
+	"io"
+
+	"github.com/valyala/fasthttp"
+)
+
 const (
 	green   = "\033[97;42m"
 	white   = "\033[90;47m"
@@ -10,3 +17,42 @@ const (
 	cyan    = "\033[97;46m"
 	reset   = "\033[0m"
 )
+
+// methodColor 返回请求方法对应的终端颜色
+func methodColor(method string) string {
+	switch method {
+	case fasthttp.MethodGet:
+		return blue
+	case fasthttp.MethodPost:
+		return cyan
+	case fasthttp.MethodPut:
+		return yellow
+	case fasthttp.MethodDelete:
+		return red
+	case fasthttp.MethodPatch:
+		return green
+	case fasthttp.MethodHead:
+		return magenta
+	case fasthttp.MethodOptions:
+		return white
+	default:
+		return reset
+	}
+}
+
+// printRoutes 以彩色表格的形式输出路由表
+func printRoutes(w io.Writer, routes []RouteInfo) {
+	width := 0
+	for _, route := range routes {
+		if len(route.Host)+len(route.Path) > width {
+			width = len(route.Host) + len(route.Path)
+		}
+	}
+
+	for _, route := range routes {
+		fmt.Fprintf(w, "[fastweb-debug] %s %-7s %s %-*s --> %s (%d middlewares)\n",
+			methodColor(route.Method), route.Method, reset,
+			width, route.Host+route.Path, route.Handler, route.Middlewares,
+		)
+	}
+}
@@ -0,0 +1,70 @@
+package fastweb
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// mountParam Mount 注册的 catch-all 参数名
+const mountParam = "mountpath"
+
+// Mount 把 handler 挂载到 prefix 下，prefix 及其下的所有路径、所有标准请求方法都交给 handler 处理。
+// handler 可以是 *Engine、fasthttp.RequestHandler 或 http.Handler，
+// handler 看到的请求路径去掉了 prefix（包括分组前缀），如挂载到 /debug 时 /debug/pprof/ 变为 /pprof/。
+// 分组中间件在 handler 之前执行
+func (group *RouterGroup) Mount(prefix string, handler interface{}) {
+	var h fasthttp.RequestHandler
+	switch x := handler.(type) {
+	case *Engine:
+		h = x.requestHandler
+	case fasthttp.RequestHandler:
+		h = x
+	case func(*fasthttp.RequestCtx):
+		h = x
+	case http.Handler:
+		h = fasthttpadaptor.NewFastHTTPHandler(x)
+	case func(http.ResponseWriter, *http.Request):
+		h = fasthttpadaptor.NewFastHTTPHandlerFunc(x)
+	default:
+		panic(fmt.Sprintf("can not mount handler of type %T at '%s'", handler, prefix))
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	mounted := mountHandler(h)
+	if prefix != "" || group.prefix != "" {
+		group.Any(prefix, mounted)
+	}
+	group.Any(prefix+"/*"+mountParam, mounted)
+}
+
+// mountHandler 以去掉挂载前缀的请求路径调用 h，h 返回后恢复原来的请求 URI
+func mountHandler(h fasthttp.RequestHandler) HandlerFunc {
+	return func(ctx Context) {
+		path, _ := ctx.URLParam(mountParam)
+		if path == "" {
+			path = "/"
+		}
+
+		// 参数引用请求路径的缓冲区，改写路径之前复制一份，h 返回后中间件读取的参数保持不变
+		if c, ok := ctx.(*context); ok {
+			for i := range c.params {
+				c.params[i].Value = string(append([]byte(nil), c.params[i].Value...))
+			}
+		}
+		path = string(append([]byte(nil), path...))
+
+		fctx := ctx.GetFctx()
+		requestURI := append([]byte(nil), fctx.Request.Header.RequestURI()...)
+
+		uri := fctx.URI()
+		uri.SetPath(path)
+		fctx.Request.SetRequestURIBytes(append([]byte(nil), uri.RequestURI()...))
+		defer fctx.Request.SetRequestURIBytes(requestURI)
+
+		h(fctx)
+	}
+}
@@ -0,0 +1,86 @@
+package fastweb
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMount(t *testing.T) {
+	var trace []string
+	sub := New()
+	sub.GET("/users/:id", func(ctx Context) {
+		id, _ := ctx.URLParam("id")
+		ctx.SetBodyStrf(200, "sub user %s", id)
+	})
+
+	engine := New()
+	admin := engine.Group("/admin")
+	admin.Use(func(ctx Context) {
+		ctx.Next()
+		trace = append(trace, ctx.Path())
+	})
+	admin.Mount("/legacy", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("legacy " + r.URL.Path + "?" + r.URL.RawQuery))
+	}))
+	engine.Mount("/raw/", func(fctx *fasthttp.RequestCtx) {
+		fctx.SetBodyString("raw " + string(fctx.Path()))
+	})
+	engine.Mount("/sub", sub)
+
+	tests := []struct {
+		method, uri string
+		code        int
+		body        string
+	}{
+		{"GET", "/admin/legacy/pages/a%20b?x=1", 200, "legacy /pages/a b?x=1"},
+		{"POST", "/admin/legacy", 200, "legacy /?"},
+		{"GET", "/raw/", 200, "raw /"},
+		{"DELETE", "/raw/a/b", 200, "raw /a/b"},
+		{"GET", "/sub/users/7", 200, "sub user 7"},
+		{"GET", "/sub/missing", 404, ""},
+	}
+	for _, tt := range tests {
+		fctx := performRequest(engine, tt.method, tt.uri)
+		if code := fctx.Response.StatusCode(); code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.uri, code, tt.code)
+			continue
+		}
+		if body := string(fctx.Response.Body()); tt.body != "" && body != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.method, tt.uri, body, tt.body)
+		}
+	}
+
+	// the group middleware runs around the mounted handler and sees the
+	// original path afterwards
+	if len(trace) != 2 || trace[0] != "/admin/legacy/pages/a b" || trace[1] != "/admin/legacy" {
+		t.Errorf("got middleware trace %q", trace)
+	}
+
+	if recv := catchPanic(func() { engine.Mount("/bad", 42) }); recv == nil {
+		t.Error("no panic for unsupported handler type")
+	}
+}
+
+func TestMountParamGroup(t *testing.T) {
+	var after string
+	engine := New()
+	tenant := engine.Group("/t/:tenant")
+	tenant.Use(func(ctx Context) {
+		ctx.Next()
+		after, _ = ctx.URLParam("tenant")
+	})
+	tenant.Mount("/m", func(fctx *fasthttp.RequestCtx) {
+		fctx.SetBodyString("mounted " + string(fctx.Path()))
+	})
+
+	fctx := performRequest(engine, "GET", "/t/acme/m/xyzxyzxyz")
+	if body := string(fctx.Response.Body()); body != "mounted /xyzxyzxyz" {
+		t.Errorf("got body %q", body)
+	}
+	// the param must not change when the mounted handler rewrites the path
+	if after != "acme" {
+		t.Errorf("got tenant %q after the mounted handler", after)
+	}
+}
@@ -7,6 +7,109 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
+// engineOption set Engine option
+type engineOption func(*Engine)
+
+// WithDebug print the route table when the server starts
+func WithDebug() engineOption {
+	return func(engine *Engine) {
+		engine.debug = true
+	}
+}
+
+// WithNotFound set the handler called when no route matches,
+// ctx.NotFound() is used by default
+func WithNotFound(handler HandlerFunc) engineOption {
+	return func(engine *Engine) {
+		engine.router.NotFound = handler
+	}
+}
+
+// WithMethodNotAllowed set the handler called when the path matches
+// but the request method doesn't, the Allow header is set before it is called
+func WithMethodNotAllowed(handler HandlerFunc) engineOption {
+	return func(engine *Engine) {
+		engine.router.MethodNotAllowed = handler
+	}
+}
+
+// WithPanicHandler set the handler called when a handler panics,
+// the recovered value is stored in UserValue("PanicError")
+func WithPanicHandler(handler HandlerFunc) engineOption {
+	return func(engine *Engine) {
+		engine.router.PanicHandler = handler
+	}
+}
+
+// WithGlobalOPTIONS set the handler called on automatic OPTIONS replies
+func WithGlobalOPTIONS(handler HandlerFunc) engineOption {
+	return func(engine *Engine) {
+		engine.router.GlobalOPTIONS = handler
+	}
+}
+
+// WithRedirectTrailingSlash enable (default) or disable redirecting /foo/ to /foo
+// (and vice versa) when only the other one is registered
+func WithRedirectTrailingSlash(enabled bool) engineOption {
+	return func(engine *Engine) {
+		engine.router.RedirectTrailingSlash = enabled
+	}
+}
+
+// WithRedirectFixedPath enable (default) or disable redirecting to the cleaned,
+// case-insensitive matching path, e.g. /..//FOO to /foo
+func WithRedirectFixedPath(enabled bool) engineOption {
+	return func(engine *Engine) {
+		engine.router.RedirectFixedPath = enabled
+	}
+}
+
+// WithRedirectPolicy set how requests are answered whose path was corrected
+// by the trailing slash or fixed path redirection, PolicyRedirect by default
+func WithRedirectPolicy(policy RedirectPolicy) engineOption {
+	return func(engine *Engine) {
+		engine.router.RedirectPolicy = policy
+	}
+}
+
+// WithUseRawPath match routes on the escaped request path, so that e.g.
+// /objects/a%2Fb matches /objects/:key with key "a/b"
+func WithUseRawPath() engineOption {
+	return func(engine *Engine) {
+		engine.router.UseRawPath = true
+	}
+}
+
+// WithCaseInsensitive match routes case-insensitively, e.g. /API/Users
+// is served by /api/users without a redirect
+func WithCaseInsensitive() engineOption {
+	return func(engine *Engine) {
+		engine.router.CaseInsensitive = true
+	}
+}
+
+// WithHandleMethodNotAllowed enable (default) or disable 405 replies,
+// 404 is replied instead when disabled
+func WithHandleMethodNotAllowed(enabled bool) engineOption {
+	return func(engine *Engine) {
+		engine.router.HandleMethodNotAllowed = enabled
+	}
+}
+
+// WithHandleOPTIONS enable (default) or disable automatic OPTIONS replies
+func WithHandleOPTIONS(enabled bool) engineOption {
+	return func(engine *Engine) {
+		engine.router.HandleOPTIONS = enabled
+	}
+}
+
+// WithHandleHEAD enable (default) or disable serving HEAD requests with GET routes
+func WithHandleHEAD(enabled bool) engineOption {
+	return func(engine *Engine) {
+		engine.router.HandleHEAD = enabled
+	}
+}
+
 // svrOption set fasthttp.Server option
 type svrOption func(*fasthttp.Server)
 
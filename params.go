@@ -2,8 +2,11 @@ package fastweb
 
 import (
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"mime/multipart"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -14,18 +17,68 @@ import (
 
 var cache sync.Map
 
-// TODO add gt lt qe le ge ne 比较符
+// 字段的取值来源，由 path、query、header、cookie、form 标签指定
+const (
+	sourceNone = iota
+	sourcePath
+	sourceQuery
+	sourceHeader
+	sourceCookie
+	sourceForm
+)
+
+var sourceTags = [...]string{
+	sourcePath:   "path",
+	sourceQuery:  "query",
+	sourceHeader: "header",
+	sourceCookie: "cookie",
+	sourceForm:   "form",
+}
+
+var (
+	fileHeaderType  = reflect.TypeOf((*multipart.FileHeader)(nil))
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader(nil))
+)
+
+// valueSource 为 bind、bindForm 提供字段的值
+type valueSource interface {
+	lookup(source int, key string) []byte     // 返回 key 在 source 中的值
+	files(key string) []*multipart.FileHeader // 返回表单中名为 key 的上传文件
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// rule 比较规则，如 gt=18、le=now+24h
+type rule struct {
+	op    string        // gt ge lt le eq ne
+	raw   string        // 标签中的写法，用于错误信息
+	i     int64         // 整数字段的比较值
+	u     uint64        // 无符号整数字段的比较值
+	f     float64       // 浮点数字段的比较值
+	t     time.Time     // 时间字段的比较值
+	now   bool          // 时间字段与当前时间比较
+	delta time.Duration // 相对当前时间的偏移，如 now-24h
+}
+
 type field struct {
 	name      string
 	key       string
+	jsonKey   string // JSON 字段名，json 标签为 "-" 时不参与 JSON 校验
+	xmlKey    string // XML 元素或属性名，xml 标签为 "-" 时不参与 XML 校验
+	exported  bool
+	source    int    // 取值来源
+	sourceKey string // 来源中的名称
 	index     int
 	t         reflect.Type
-	required  bool   // 是否必须
-	maxlength int    // 最大长度(字段必须是 string类型）
-	minlength int    // 最小长度(字段必须是 string类型）
-	strip     bool   // 是否自动去除值两侧的空白字符（字段必须是 string类型）
-	re        string // 自定义正则表达式（字段必须是 string类形象）
-	format    string // 日期类型格式化
+	required  bool     // 是否必须
+	maxlength int      // 最大长度(字段必须是 string类型）
+	minlength int      // 最小长度(字段必须是 string类型）
+	strip     bool     // 是否自动去除值两侧的空白字符（字段必须是 string类型）
+	re        string   // 自定义正则表达式（字段必须是 string类形象）
+	format    string   // 日期类型格式化，默认为 RFC3339
+	rules     []rule   // 比较规则（字段必须是整数、浮点数或 time.Time 类型）
+	oneof     []string // 可选值（字段必须是 string、整数或浮点数类型）
+	oneofEq   []rule   // 数字字段的可选值，解析为 eq 规则后按数值比较
 }
 
 func (f *field) setvalue(obj reflect.Value, v []byte) error {
@@ -40,32 +93,21 @@ func (f *field) setvalue(obj reflect.Value, v []byte) error {
 			v = bytes.TrimSpace(v)
 		}
 
-		if f.maxlength > 0 && len(v) > f.maxlength {
-			return fmt.Errorf("%s field value is too long", f.name)
-		}
-
-		if f.minlength > 0 && len(v) < f.minlength {
-			return fmt.Errorf("%s field value is too short", f.name)
-		}
-
-		if len(f.re) > 0 {
-			ismatch, err := regexp.Match(f.re, v)
-			if err != nil {
-				return err
-			}
-			if !ismatch {
-				return fmt.Errorf("%s field regular match failed", f.name)
-			}
+		if err := f.checkString(f.name, v); err != nil {
+			return err
 		}
-		fv.SetString(b2s(v))
+		fv.SetString(string(v)) // v 可能引用 fasthttp 的缓冲区，需要复制
 	case reflect.Struct:
-		if len(f.format) > 0 && f.t.Name() == "Time" {
-			datetime, err := time.Parse(f.format, b2s(v))
+		if f.t == timeType {
+			format := f.format
+			if len(format) == 0 {
+				format = time.RFC3339
+			}
+			datetime, err := time.Parse(format, b2s(v))
 			if err != nil {
 				return err
 			}
 			fv.Set(reflect.ValueOf(datetime))
-			return nil
 		}
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		n, err := strconv.Atoi(b2s(v))
@@ -73,16 +115,247 @@ func (f *field) setvalue(obj reflect.Value, v []byte) error {
 			return err
 		}
 		fv.SetInt(int64(n))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(b2s(v), 10, f.t.Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
 	case reflect.Float32, reflect.Float64:
-		float, err := strconv.ParseFloat(b2s(v), len(v))
+		float, err := strconv.ParseFloat(b2s(v), f.t.Bits())
 		if err != nil {
 			return err
 		}
 		fv.SetFloat(float)
 	}
+	return f.compare(fv, f.name)
+}
+
+// fill 用 value 为字段赋值，value 为空时只检查字段是否必须，key 为错误信息中的名称
+func (f *field) fill(obj reflect.Value, key string, value []byte) error {
+	if len(value) == 0 {
+		if f.required {
+			return fmt.Errorf("%s[%s] is required", f.name, key)
+		}
+		return nil
+	}
+	return f.setvalue(obj, value)
+}
+
+// isFile 字段是否为 *multipart.FileHeader 或 []*multipart.FileHeader 类型
+func (f *field) isFile() bool {
+	return f.t == fileHeaderType || f.t == fileHeadersType
+}
+
+// fillFiles 用上传的文件为字段赋值，*multipart.FileHeader 类型的字段取第一个文件
+func (f *field) fillFiles(obj reflect.Value, key string, files []*multipart.FileHeader) error {
+	if len(files) == 0 {
+		if f.required {
+			return fmt.Errorf("%s[%s] is required", f.name, key)
+		}
+		return nil
+	}
+
+	fv := obj.Field(f.index)
+	if f.t == fileHeaderType {
+		fv.Set(reflect.ValueOf(files[0]))
+	} else {
+		fv.Set(reflect.ValueOf(files))
+	}
 	return nil
 }
 
+// checkString 校验字符串的长度和正则表达式，name 为错误信息中的字段名
+func (f *field) checkString(name string, v []byte) error {
+	if f.maxlength > 0 && len(v) > f.maxlength {
+		return fmt.Errorf("%s field value is too long", name)
+	}
+
+	if f.minlength > 0 && len(v) < f.minlength {
+		return fmt.Errorf("%s field value is too short", name)
+	}
+
+	if len(f.re) > 0 {
+		ismatch, err := regexp.Match(f.re, v)
+		if err != nil {
+			return err
+		}
+		if !ismatch {
+			return fmt.Errorf("%s field regular match failed", name)
+		}
+	}
+	return nil
+}
+
+// check 校验已经由解码器（如 encoding/json）赋值的字段，name 为错误信息中的字段名，
+// present 表示请求体中是否有该字段。非必须的字段为零值时只校验比较规则和可选值，请求体中没有该字段时不做校验
+func (f *field) check(obj reflect.Value, name string, present bool) error {
+	fv := obj.Field(f.index)
+	if fv.IsZero() {
+		if f.required {
+			return fmt.Errorf("%s[%s] is required", f.name, name)
+		}
+		if present {
+			return f.compare(fv, name)
+		}
+		return nil
+	}
+
+	if f.t.Kind() == reflect.String {
+		v := fv.String()
+		if f.strip {
+			v = strings.TrimSpace(v)
+			fv.SetString(v)
+		}
+		if len(v) == 0 && f.required {
+			return fmt.Errorf("%s[%s] is required", f.name, name)
+		}
+		if err := f.checkString(name, s2b(v)); err != nil {
+			return err
+		}
+	}
+	return f.compare(fv, name)
+}
+
+// compare 校验 fv 是否满足比较规则和可选值，name 为错误信息中的字段名
+func (f *field) compare(fv reflect.Value, name string) error {
+	for _, r := range f.rules {
+		c := f.cmp(fv, r)
+		var ok bool
+		switch r.op {
+		case "gt":
+			ok = c > 0
+		case "ge":
+			ok = c >= 0
+		case "lt":
+			ok = c < 0
+		case "le":
+			ok = c <= 0
+		case "eq":
+			ok = c == 0
+		case "ne":
+			ok = c != 0
+		}
+		if !ok {
+			return fmt.Errorf("%s field %s check failed", name, r.raw)
+		}
+	}
+
+	if len(f.oneof) > 0 {
+		if f.t.Kind() == reflect.String {
+			for _, o := range f.oneof {
+				if o == fv.String() {
+					return nil
+				}
+			}
+		} else {
+			for _, r := range f.oneofEq {
+				if f.cmp(fv, r) == 0 {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%s field oneof=%s check failed", name, strings.Join(f.oneof, "|"))
+	}
+	return nil
+}
+
+// cmp 比较字段的值 fv 和规则 r 的值，小于、等于、大于时分别返回 -1、0、1
+func (f *field) cmp(fv reflect.Value, r rule) int {
+	switch {
+	case f.t == timeType:
+		t := r.t
+		if r.now {
+			t = time.Now().Add(r.delta)
+		}
+		v := fv.Interface().(time.Time)
+		return compareInt(v.UnixNano(), t.UnixNano())
+	case isInt(f.t.Kind()):
+		return compareInt(fv.Int(), r.i)
+	case isUint(f.t.Kind()):
+		switch v := fv.Uint(); {
+		case v < r.u:
+			return -1
+		case v > r.u:
+			return 1
+		}
+		return 0
+	}
+	switch v := fv.Float(); {
+	case v < r.f:
+		return -1
+	case v > r.f:
+		return 1
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func isInt(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Int64
+}
+
+func isUint(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uint64
+}
+
+func isFloat(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// parseRule 解析比较规则 opt，如 gt=18、ge=now-1h，时间值按 format 解析，未设置 format 时使用 RFC3339
+func (f *field) parseRule(opt string) (rule, error) {
+	kv := strings.SplitN(opt, "=", 2)
+	if len(kv) != 2 || len(kv[1]) == 0 {
+		return rule{}, fmt.Errorf("%s: invalid rule %q", f.name, opt)
+	}
+	r := rule{op: kv[0], raw: opt}
+	switch r.op {
+	case "gt", "ge", "lt", "le", "eq", "ne":
+	default:
+		return rule{}, fmt.Errorf("%s: unknown rule %q", f.name, opt)
+	}
+
+	var err error
+	value := kv[1]
+	switch {
+	case f.t == timeType:
+		if strings.HasPrefix(value, "now") {
+			r.now = true
+			if len(value) > 3 {
+				r.delta, err = time.ParseDuration(strings.TrimPrefix(value[3:], "+"))
+			}
+			break
+		}
+		format := f.format
+		if len(format) == 0 {
+			format = time.RFC3339
+		}
+		r.t, err = time.Parse(format, value)
+	case isInt(f.t.Kind()):
+		r.i, err = strconv.ParseInt(value, 10, 64)
+	case isUint(f.t.Kind()):
+		r.u, err = strconv.ParseUint(value, 10, 64)
+	case isFloat(f.t.Kind()):
+		r.f, err = strconv.ParseFloat(value, f.t.Bits())
+	default:
+		return rule{}, fmt.Errorf("%s: %s only supports numbers and time.Time", f.name, r.op)
+	}
+	if err != nil {
+		return rule{}, fmt.Errorf("%s: invalid rule %q: %v", f.name, opt, err)
+	}
+	return r, nil
+}
+
 func (f *field) tagparse(tag string) error {
 	opts := strings.Split(tag, ",")
 	key, opts := opts[0], opts[1:]
@@ -92,6 +365,7 @@ func (f *field) tagparse(tag string) error {
 		f.key = f.name
 	}
 
+	var rules []string
 	for _, opt := range opts {
 		switch opt[0] {
 		case 'r':
@@ -128,7 +402,39 @@ func (f *field) tagparse(tag string) error {
 			if len(format) > 1 {
 				f.format = format[1]
 			}
+		case 'g', 'l', 'e', 'n':
+			rules = append(rules, opt)
+		case 'o':
+			kv := strings.SplitN(opt, "=", 2)
+			if kv[0] != "oneof" || len(kv) != 2 {
+				return fmt.Errorf("%s: invalid rule %q", f.name, opt)
+			}
+			k := f.t.Kind()
+			if k != reflect.String && !isInt(k) && !isUint(k) && !isFloat(k) {
+				return fmt.Errorf("%s: oneof only supports strings and numbers", f.name)
+			}
+			f.oneof = strings.Split(kv[1], "|")
+			if k == reflect.String {
+				break
+			}
+			// 数字按数值比较，如 oneof=1.50|2 匹配 1.5
+			for _, o := range f.oneof {
+				r, err := f.parseRule("eq=" + o)
+				if err != nil {
+					return fmt.Errorf("%s: invalid rule %q: %v", f.name, opt, err)
+				}
+				f.oneofEq = append(f.oneofEq, r)
+			}
+		}
+	}
+
+	// format 可能写在比较规则之后
+	for _, opt := range rules {
+		r, err := f.parseRule(opt)
+		if err != nil {
+			return err
 		}
+		f.rules = append(f.rules, r)
 	}
 	return nil
 }
@@ -136,6 +442,7 @@ func (f *field) tagparse(tag string) error {
 type params struct {
 	name   string
 	fields map[string]*field
+	list   []*field // 按结构体中的顺序排列的字段
 }
 
 func (p *params) padding(key, value []byte, obj interface{}) error {
@@ -165,15 +472,133 @@ func (p *params) valid(obj interface{}) error {
 	return nil
 }
 
+// bind 按字段的来源标签为字段赋值，
+// *multipart.FileHeader 和 []*multipart.FileHeader 类型的字段只能使用 form 标签
+func (p *params) bind(obj interface{}, src valueSource) error {
+	v := reflect.ValueOf(obj).Elem()
+	for _, f := range p.list {
+		if f.source == sourceNone || !f.exported {
+			continue
+		}
+
+		var err error
+		if f.isFile() {
+			if f.source == sourceForm {
+				err = f.fillFiles(v, f.sourceKey, src.files(f.sourceKey))
+			}
+		} else {
+			err = f.fill(v, f.sourceKey, src.lookup(f.source, f.sourceKey))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindForm 从表单中为所有字段赋值，有 form 标签的字段使用标签中的名称，其他字段使用 valid 标签中的名称
+func (p *params) bindForm(obj interface{}, src valueSource) error {
+	v := reflect.ValueOf(obj).Elem()
+	for _, f := range p.list {
+		if !f.exported {
+			continue
+		}
+
+		key := f.key
+		if f.source == sourceForm {
+			key = f.sourceKey
+		}
+		var err error
+		if f.isFile() {
+			err = f.fillFiles(v, key, src.files(key))
+		} else {
+			err = f.fill(v, key, src.lookup(sourceForm, key))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validJSON 校验从 body 解码的结构体，错误信息中使用 JSON 字段名
+func (p *params) validJSON(obj interface{}, body []byte) error {
+	// encoding/json 匹配字段名时不区分大小写
+	var raw map[string]json.RawMessage
+	json.Unmarshal(body, &raw)
+	present := make(map[string]bool, len(raw))
+	for key := range raw {
+		present[strings.ToLower(key)] = true
+	}
+	return p.validDecoded(obj, func(f *field) (string, bool) {
+		return f.jsonKey, present[strings.ToLower(f.jsonKey)]
+	})
+}
+
+// validXML 校验从 body 解码的结构体，错误信息中使用 XML 元素名
+func (p *params) validXML(obj interface{}, body []byte) error {
+	present := xmlNames(body)
+	return p.validDecoded(obj, func(f *field) (string, bool) {
+		return f.xmlKey, present[f.xmlKey]
+	})
+}
+
+// xmlNames 返回根元素的属性名和直接子元素名
+func xmlNames(body []byte) map[string]bool {
+	names := make(map[string]bool)
+	d := xml.NewDecoder(bytes.NewReader(body))
+	depth := 0
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return names
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 1:
+				for _, attr := range t.Attr {
+					names[attr.Name.Local] = true
+				}
+			case 2:
+				names[t.Name.Local] = true
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// validDecoded 校验解码后的结构体，name 返回错误信息中的字段名和请求体中是否有该字段，
+// 字段名为 "-" 时跳过该字段
+func (p *params) validDecoded(obj interface{}, name func(f *field) (string, bool)) error {
+	v := reflect.ValueOf(obj).Elem()
+	for _, f := range p.list {
+		key, present := name(f)
+		if !f.exported || key == "-" {
+			continue
+		}
+		if err := f.check(v, key, present); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func scan(obj interface{}) (*params, error) {
 	t := reflect.TypeOf(obj)
 	if t.Kind() != reflect.Ptr {
 		return nil, fmt.Errorf("non-pointer: %s", t.Name())
 	}
 	t = t.Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("non-struct: %s", t)
+	}
 	pname := t.Name()
 
-	if p, ok := cache.Load(pname); ok {
+	// 以类型为键，不同包中的同名结构体和匿名结构体不会冲突
+	if p, ok := cache.Load(t); ok {
 		return p.(*params), nil
 	}
 
@@ -181,13 +606,31 @@ func scan(obj interface{}) (*params, error) {
 	for i := 0; i < t.NumField(); i++ {
 		sf := t.Field(i)
 		tag := sf.Tag.Get("valid")
-		f := &field{name: sf.Name, index: i, t: sf.Type}
+		f := &field{name: sf.Name, index: i, t: sf.Type, exported: sf.PkgPath == ""}
 		err := f.tagparse(tag)
 		if err != nil {
 			return nil, err
 		}
+		f.jsonKey, f.xmlKey = sf.Name, sf.Name
+		if name := strings.SplitN(sf.Tag.Get("json"), ",", 2)[0]; len(name) > 0 {
+			f.jsonKey = name
+		}
+		if name := strings.SplitN(sf.Tag.Get("xml"), ",", 2)[0]; len(name) > 0 {
+			// "namespace-URL name"
+			f.xmlKey = name[strings.LastIndexByte(name, ' ')+1:]
+		}
+		for source := sourcePath; source < len(sourceTags); source++ {
+			if key, ok := sf.Tag.Lookup(sourceTags[source]); ok {
+				f.source, f.sourceKey = source, key
+				if len(key) == 0 {
+					f.sourceKey = f.key
+				}
+				break
+			}
+		}
 		p.fields[f.key] = f
+		p.list = append(p.list, f)
 	}
-	cache.Store(pname, p)
+	cache.Store(t, p)
 	return p, nil
 }
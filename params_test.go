@@ -1,7 +1,13 @@
 package fastweb
 
 import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
@@ -46,3 +52,358 @@ func TestPadding(t *testing.T) {
 	}
 	t.Logf("%+v\n", r)
 }
+
+type jsonReq struct {
+	Username string `json:"user_name" valid:"username,required,strip,minlength=2,maxlength=18"`
+	Password string `json:"password" valid:"passwd,required,minlength=6"`
+	Age      int    `json:"age" valid:"age,required"`
+	Nickname string `valid:"nickname,maxlength=4"`
+}
+
+func TestBindJSON(t *testing.T) {
+	bind := func(body string) (*jsonReq, error) {
+		fctx := &fasthttp.RequestCtx{}
+		fctx.Request.Header.SetMethod("POST")
+		fctx.Request.SetBodyString(body)
+		ctx := &context{}
+		ctx.Init(fctx)
+		r := &jsonReq{}
+		return r, ctx.BindJSON(r)
+	}
+
+	r, err := bind(`{"user_name":"  zhangsan ","password":"password123","age":18}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Username != "zhangsan" || r.Password != "password123" || r.Age != 18 {
+		t.Fatalf("unexpected result %+v", r)
+	}
+
+	for body, want := range map[string]string{
+		`{"user_name":"zhangsan","password":"password123"}`:                            "Age[age] is required",
+		`{"user_name":" z ","password":"password123","age":18}`:                        "user_name field value is too short",
+		`{"user_name":"zhangsan","password":"123","age":18}`:                           "password field value is too short",
+		`{"user_name":"zhangsan","password":"password123","age":1,"Nickname":"abcde"}`: "Nickname field value is too long",
+	} {
+		if _, err := bind(body); err == nil || err.Error() != want {
+			t.Errorf("%s: error = %v, want %q", body, err, want)
+		}
+	}
+
+	if _, err := bind(`{"user_name":`); err == nil {
+		t.Error("expected a decode error")
+	}
+}
+
+func TestBind(t *testing.T) {
+	type bindReq struct {
+		ID     int     `path:"id" valid:",required"`
+		Page   int     `query:"page"`
+		Tenant string  `header:"X-Tenant" valid:",required"`
+		SID    string  `cookie:"sid" valid:",minlength=4"`
+		Name   string  `form:"" valid:"name,strip,maxlength=8"`
+		Score  float64 `form:"score"`
+		Other  string
+	}
+
+	var (
+		got     bindReq
+		bindErr error
+	)
+	engine := New()
+	engine.POST("/users/:id", func(ctx Context) {
+		got = bindReq{}
+		bindErr = ctx.Bind(&got)
+	})
+	request := func(uri, tenant, sid, body string) {
+		fctx := &fasthttp.RequestCtx{}
+		fctx.Request.Header.SetMethod("POST")
+		fctx.Request.SetRequestURI(uri)
+		fctx.Request.Header.SetContentType("application/x-www-form-urlencoded")
+		if tenant != "" {
+			fctx.Request.Header.Set("X-Tenant", tenant)
+		}
+		if sid != "" {
+			fctx.Request.Header.SetCookie("sid", sid)
+		}
+		fctx.Request.SetBodyString(body)
+		engine.requestHandler(fctx)
+	}
+
+	request("/users/42?page=3&Other=x", "acme", "abcdef", "name=+bob+&score=9.5")
+	if bindErr != nil {
+		t.Fatal(bindErr)
+	}
+	want := bindReq{ID: 42, Page: 3, Tenant: "acme", SID: "abcdef", Name: "bob", Score: 9.5}
+	if got != want {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	for _, tt := range []struct {
+		uri, tenant, sid, body, want string
+	}{
+		{"/users/42", "", "", "", "Tenant[X-Tenant] is required"},
+		{"/users/42", "acme", "abc", "", "SID field value is too short"},
+		{"/users/42", "acme", "", "name=alexander", "Name field value is too long"},
+	} {
+		request(tt.uri, tt.tenant, tt.sid, tt.body)
+		if bindErr == nil || bindErr.Error() != tt.want {
+			t.Errorf("%+v: error = %v", tt, bindErr)
+		}
+	}
+
+	request("/users/abc", "acme", "", "")
+	if bindErr == nil {
+		t.Error("expected an error for a non-numeric path param")
+	}
+}
+
+func TestShouldBind(t *testing.T) {
+	type user struct {
+		Name string `json:"name" xml:"name" valid:"name,required,strip,maxlength=8"`
+		Age  int    `json:"age" xml:"age" valid:"age"`
+	}
+
+	var multipartBody bytes.Buffer
+	w := multipart.NewWriter(&multipartBody)
+	w.WriteField("name", " bob ")
+	w.WriteField("age", "20")
+	w.Close()
+
+	bind := func(contentType, body string) (user, error) {
+		fctx := &fasthttp.RequestCtx{}
+		fctx.Request.Header.SetMethod("POST")
+		fctx.Request.Header.SetContentType(contentType)
+		fctx.Request.SetBodyString(body)
+		ctx := &context{}
+		ctx.Init(fctx)
+		var u user
+		err := ctx.ShouldBind(&u)
+		return u, err
+	}
+
+	want := user{Name: "bob", Age: 20}
+	for _, tt := range []struct{ contentType, body string }{
+		{"application/x-www-form-urlencoded", "name=+bob+&age=20"},
+		{w.FormDataContentType(), multipartBody.String()},
+		{"application/json; charset=utf-8", `{"name":" bob ","age":20}`},
+		{"application/xml", "<user><name> bob </name><age>20</age></user>"},
+		{"Text/XML", "<user><name>bob</name><age>20</age></user>"},
+	} {
+		u, err := bind(tt.contentType, tt.body)
+		if err != nil {
+			t.Errorf("%s: %v", tt.contentType, err)
+		} else if u != want {
+			t.Errorf("%s: got %+v, want %+v", tt.contentType, u, want)
+		}
+	}
+
+	for _, tt := range []struct{ contentType, body, want string }{
+		{"application/x-www-form-urlencoded", "age=20", "Name[name] is required"},
+		{"application/xml", "<user><name>alexander</name></user>", "name field value is too long"},
+		{"application/json", `{"age":20}`, "Name[name] is required"},
+	} {
+		if _, err := bind(tt.contentType, tt.body); err == nil || err.Error() != tt.want {
+			t.Errorf("%s: error = %v, want %q", tt.contentType, err, tt.want)
+		}
+	}
+
+	// the same struct works with Bind and ShouldBind
+	type post struct {
+		Title string `form:"title" valid:",required"`
+		Body  string `valid:"body"`
+	}
+	for _, tt := range []struct{ contentType, body string }{
+		{"application/x-www-form-urlencoded", "title=x&body=y"},
+		{w.FormDataContentType(), "--" + w.Boundary() + "\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nx\r\n" +
+			"--" + w.Boundary() + "\r\nContent-Disposition: form-data; name=\"body\"\r\n\r\ny\r\n--" + w.Boundary() + "--\r\n"},
+	} {
+		fctx := &fasthttp.RequestCtx{}
+		fctx.Request.Header.SetMethod("POST")
+		fctx.Request.Header.SetContentType(tt.contentType)
+		fctx.Request.SetBodyString(tt.body)
+		ctx := &context{}
+		ctx.Init(fctx)
+		var bound, shouldBound post
+		if err := ctx.Bind(&bound); err != nil || bound.Title != "x" {
+			t.Errorf("%s: Bind got %+v, %v", tt.contentType, bound, err)
+		}
+		if err := ctx.ShouldBind(&shouldBound); err != nil || shouldBound != (post{Title: "x", Body: "y"}) {
+			t.Errorf("%s: ShouldBind got %+v, %v", tt.contentType, shouldBound, err)
+		}
+	}
+
+	for _, ct := range []string{"text/plain", ""} {
+		if _, err := bind(ct, "name=bob"); err != ErrUnsupportedMediaType {
+			t.Errorf("%q: error = %v, want ErrUnsupportedMediaType", ct, err)
+		}
+	}
+}
+
+func TestCompareRules(t *testing.T) {
+	type ruleReq struct {
+		Age    int       `json:"age" xml:"age" valid:"age,ge=18,lt=130"`
+		Score  float64   `json:"score" valid:"score,gt=0,le=100"`
+		Level  int       `json:"level" valid:"level,ne=3"`
+		Count  int       `json:"count" xml:"count,attr" valid:"count,ne=0"`
+		Status string    `json:"status" valid:"status,oneof=draft|published"`
+		Start  time.Time `json:"start" valid:"start,gt=now-1h,le=now+24h"`
+	}
+	bind := func(contentType, body string) error {
+		fctx := &fasthttp.RequestCtx{}
+		fctx.Request.Header.SetMethod("POST")
+		fctx.Request.Header.SetContentType(contentType)
+		fctx.Request.SetBodyString(body)
+		ctx := &context{}
+		ctx.Init(fctx)
+		return ctx.ShouldBind(&ruleReq{})
+	}
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"age":    18,
+		"score":  99.5,
+		"level":  2,
+		"count":  1,
+		"status": "draft",
+		"start":  now.Add(time.Hour),
+	}
+	// body returns the JSON of the valid fields with key set to value
+	body := func(key string, value interface{}) string {
+		fields := make(map[string]interface{}, len(valid))
+		for k, v := range valid {
+			fields[k] = v
+		}
+		fields[key] = value
+		b, _ := json.Marshal(fields)
+		return string(b)
+	}
+	if err := bind("application/json", body("age", 18)); err != nil {
+		t.Fatal(err)
+	}
+	// absent fields which are not required are not checked
+	if err := bind("application/json", `{}`); err != nil {
+		t.Errorf("empty body: %v", err)
+	}
+
+	tests := []struct {
+		key   string
+		value interface{}
+		want  string
+	}{
+		{"age", 17, "age field ge=18 check failed"},
+		{"age", 130, "age field lt=130 check failed"},
+		{"age", 0, "age field ge=18 check failed"},
+		{"score", 100.5, "score field le=100 check failed"},
+		{"score", 0, "score field gt=0 check failed"},
+		{"level", 3, "level field ne=3 check failed"},
+		{"count", 0, "count field ne=0 check failed"},
+		{"status", "deleted", "status field oneof=draft|published check failed"},
+		{"status", "", "status field oneof=draft|published check failed"},
+		{"start", now.Add(-2 * time.Hour), "start field gt=now-1h check failed"},
+		{"start", now.Add(48 * time.Hour), "start field le=now+24h check failed"},
+		{"start", time.Time{}, "start field gt=now-1h check failed"},
+	}
+	for _, tt := range tests {
+		if err := bind("application/json", body(tt.key, tt.value)); err == nil || err.Error() != tt.want {
+			t.Errorf("%s=%v: error = %v, want %q", tt.key, tt.value, err, tt.want)
+		}
+	}
+
+	for xmlBody, want := range map[string]string{
+		`<r count="1"><age>0</age></r>`: "age field ge=18 check failed",
+		`<r count="0"></r>`:             "count field ne=0 check failed",
+		`<r count="1"></r>`:             "",
+	} {
+		if err := bind("application/xml", xmlBody); (err == nil) != (want == "") || err != nil && err.Error() != want {
+			t.Errorf("%s: error = %v, want %q", xmlBody, err, want)
+		}
+	}
+
+	type queryReq struct {
+		Page   int       `valid:"page,gt=0"`
+		Day    time.Time `valid:"day,format=2006-01-02,ge=2020-01-01"`
+		Ratio  float32   `valid:"ratio,oneof=0.1|0.2"`
+		Weight float32   `valid:"weight,le=0.1"`
+		Price  float64   `valid:"price,oneof=1.50|2"`
+		Size   uint      `valid:"size,gt=0,le=10"`
+		Kind   uint8     `valid:"kind,oneof=1|02"`
+	}
+	r := &queryReq{}
+	p, err := scan(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.padding([]byte("page"), []byte("0"), r); err == nil || err.Error() != "Page field gt=0 check failed" {
+		t.Errorf("page=0: error = %v", err)
+	}
+	if err := p.padding([]byte("day"), []byte("2019-12-31"), r); err == nil || err.Error() != "Day field ge=2020-01-01 check failed" {
+		t.Errorf("day=2019-12-31: error = %v", err)
+	}
+	if err := p.padding([]byte("day"), []byte("2020-01-01"), r); err != nil {
+		t.Error(err)
+	}
+
+	// time fields without format use RFC3339 like the values of the rules
+	type bindTime struct {
+		Start time.Time `query:"start" valid:"start,ge=now-1h"`
+	}
+	for value, want := range map[string]string{
+		now.Format(time.RFC3339):                     "",
+		now.Add(-2 * time.Hour).Format(time.RFC3339): "Start field ge=now-1h check failed",
+		"2020-01-01": "parsing time",
+	} {
+		fctx := &fasthttp.RequestCtx{}
+		fctx.Request.SetRequestURI("/?start=" + url.QueryEscape(value))
+		ctx := &context{}
+		ctx.Init(fctx)
+		var bt bindTime
+		err := ctx.Bind(&bt)
+		if want == "" {
+			if err != nil || bt.Start.Unix() != now.Unix() {
+				t.Errorf("start=%s: got %v, %v", value, bt.Start, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("start=%s: error = %v, want %q", value, err, want)
+		}
+	}
+	// float32 values are compared with the precision of the field
+	for key, value := range map[string]string{"ratio": "0.1", "weight": "0.1"} {
+		if err := p.padding([]byte(key), []byte(value), r); err != nil {
+			t.Errorf("%s=%s: %v", key, value, err)
+		}
+	}
+	for _, tt := range []struct{ key, value, want string }{
+		{"ratio", "0.3", "Ratio field oneof=0.1|0.2 check failed"},
+		// oneof compares numbers by value
+		{"price", "1.5", ""},
+		{"price", "2.0", ""},
+		{"price", "1.6", "Price field oneof=1.50|2 check failed"},
+		{"size", "10", ""},
+		{"size", "0", "Size field gt=0 check failed"},
+		{"size", "11", "Size field le=10 check failed"},
+		{"kind", "2", ""},
+		{"kind", "3", "Kind field oneof=1|02 check failed"},
+	} {
+		err := p.padding([]byte(tt.key), []byte(tt.value), r)
+		if (err == nil) != (tt.want == "") || err != nil && err.Error() != tt.want {
+			t.Errorf("%s=%s: error = %v, want %q", tt.key, tt.value, err, tt.want)
+		}
+	}
+
+	for _, v := range []interface{}{
+		&struct {
+			Name string `valid:"name,gt=1"`
+		}{},
+		&struct {
+			Age int `valid:"age,gx=1"`
+		}{},
+		&struct {
+			Age int `valid:"age,lt=abc"`
+		}{},
+	} {
+		if _, err := scan(v); err == nil {
+			t.Errorf("%T: expected a tag error", v)
+		}
+	}
+}
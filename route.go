@@ -0,0 +1,213 @@
+package fastweb
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Route 已注册的路由，由 GET、POST 等注册方法返回，处理请求时可以通过 Context.Route 获取。
+// 路由树中保存的是 Route 的副本，Tag、Permission 等方法修改元数据后替换路由树中的副本，
+// 服务运行时也可以安全地设置元数据。直接修改字段不会生效
+type Route struct {
+	Method      string                 // 请求方法
+	Path        string                 // 完整的路由模式，包含分组前缀，如 /users/:id
+	Host        string                 // 路由所属的 Host，默认路由树中的路由为空
+	Prefix      string                 // 路由所属分组的前缀
+	Tags        []string               // 标签，如文档分类
+	Permissions []string               // 访问路由需要的权限
+	Description string                 // 路由说明
+	Meta        map[string]interface{} // 其他元数据
+	name        string
+	engine      *Engine
+	router      *Router // 路由所在的路由树
+	origin      *Route  // 路由树中的副本由哪个 Route 复制而来
+}
+
+// RouteInfo 路由表中的一条路由信息
+type RouteInfo struct {
+	Method      string // 请求方法
+	Path        string // 完整的路由模式
+	Handler     string // 路由处理器的函数名
+	Middlewares int    // 中间件（分组中间件和路由中间件）数量
+	Host        string // 路由所属的 Host，默认路由树中的路由为空
+	Route       *Route // 注册的路由及其元数据，Router.ServeFiles 注册的路由为 nil
+}
+
+// Routes 返回已注册的所有路由，默认路由树中的路由在前，Host 路由按 Host 的注册顺序排列，
+// 同一路由树中的路由按路径和请求方法排序
+func (engine *Engine) Routes() []RouteInfo {
+	routes := engine.router.routes()
+	for _, h := range engine.hosts {
+		for _, route := range h.router.routes() {
+			route.Host = h.pattern
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// Name 为路由命名，之后可以通过 Engine.URL 或 Context.URLFor 反向生成 URL。
+// 名称在同一个 Engine 中必须唯一
+func (r *Route) Name(name string) *Route {
+	if r.origin != nil {
+		r = r.origin
+	}
+	engine := r.engine
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+
+	if _, ok := engine.namedRoutes[name]; ok {
+		panic("route name '" + name + "' is already registered")
+	}
+	if engine.namedRoutes == nil {
+		engine.namedRoutes = make(map[string]*Route)
+	}
+	engine.namedRoutes[name] = r
+	return r.update(func(r *Route) {
+		r.name = name
+	})
+}
+
+// RouteName 返回路由的名称，未命名时为空
+func (r *Route) RouteName() string {
+	return r.name
+}
+
+// Tag 为路由添加标签
+func (r *Route) Tag(tags ...string) *Route {
+	return r.update(func(r *Route) {
+		r.Tags = append(r.Tags, tags...)
+	})
+}
+
+// Permission 添加访问路由需要的权限
+func (r *Route) Permission(permissions ...string) *Route {
+	return r.update(func(r *Route) {
+		r.Permissions = append(r.Permissions, permissions...)
+	})
+}
+
+// Describe 设置路由说明
+func (r *Route) Describe(description string) *Route {
+	return r.update(func(r *Route) {
+		r.Description = description
+	})
+}
+
+// SetMeta 设置元数据
+func (r *Route) SetMeta(key string, value interface{}) *Route {
+	return r.update(func(r *Route) {
+		if r.Meta == nil {
+			r.Meta = make(map[string]interface{})
+		}
+		r.Meta[key] = value
+	})
+}
+
+// update 调用 fn 修改 r，然后用 r 的新副本替换路由树中的副本。
+// r 为路由树中的副本（如 Context.Route 的返回值）时传给 fn 的是复制它的 Route
+func (r *Route) update(fn func(r *Route)) *Route {
+	if r.origin != nil {
+		r = r.origin
+	}
+	r.router.mu.Lock()
+	defer r.router.mu.Unlock()
+
+	fn(r)
+	r.router.replaceRoute(r.Method, r, r.snapshot())
+	return r
+}
+
+// snapshot 返回保存到路由树中的副本，副本不与 r 共享切片和 map
+func (r *Route) snapshot() *Route {
+	c := *r
+	c.Tags = append([]string(nil), r.Tags...)
+	c.Permissions = append([]string(nil), r.Permissions...)
+	if r.Meta != nil {
+		c.Meta = make(map[string]interface{}, len(r.Meta))
+		for k, v := range r.Meta {
+			c.Meta[k] = v
+		}
+	}
+	c.origin = r
+	return &c
+}
+
+// URL 根据路由名称和参数生成路径，params 为 key, value 交替排列的参数列表，如
+//
+//	engine.URL("user_orders", "id", "42") // /users/42/orders
+//
+// 参数值会被转义，catch-all 参数中的 '/' 会被保留
+func (engine *Engine) URL(name string, params ...string) (string, error) {
+	engine.mu.RLock()
+	route, ok := engine.namedRoutes[name]
+	engine.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("route %q is not found", name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("odd number of parameters to build route %q", name)
+	}
+	return buildURL(route.Path, name, params)
+}
+
+// buildURL 用参数填充 pattern 中的 :param 和 *catchAll
+func buildURL(pattern, name string, params []string) (string, error) {
+	var b strings.Builder
+	b.Grow(len(pattern))
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != ':' && c != '*' {
+			b.WriteByte(c)
+			continue
+		}
+
+		// find wildcard end, the name may be followed by a constraint
+		end := wildcardEnd(pattern, i)
+		if end < 0 {
+			end = len(pattern)
+		}
+		key, expr := splitWildcard(pattern[i:end])
+		i = end - 1
+
+		value, ok := lookupParam(params, key)
+		if !ok || (c == ':' && value == "") {
+			return "", fmt.Errorf("missing parameter %q to build route %q", key, name)
+		}
+		if len(expr) > 0 {
+			cons, err := compileConstraint(expr)
+			if err != nil {
+				return "", err
+			}
+			if !cons.match(value) {
+				return "", fmt.Errorf("parameter %q does not match constraint '%s' of route %q", key, expr, name)
+			}
+		}
+
+		if c == ':' {
+			b.WriteString(url.PathEscape(value))
+			continue
+		}
+
+		// catchAll: the leading '/' is already part of the pattern
+		segments := strings.Split(strings.TrimPrefix(value, "/"), "/")
+		for j, seg := range segments {
+			if j > 0 {
+				b.WriteByte('/')
+			}
+			b.WriteString(url.PathEscape(seg))
+		}
+	}
+	return b.String(), nil
+}
+
+func lookupParam(params []string, key string) (string, bool) {
+	for i := 0; i+1 < len(params); i += 2 {
+		if params[i] == key {
+			return params[i+1], true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,230 @@
+package fastweb
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestURL(t *testing.T) {
+	handler := func(ctx Context) {}
+	engine := New()
+	users := engine.Group("/users")
+	users.GET("/:id/orders", handler).Name("user_orders")
+	engine.GET("/src/*filepath", handler).Name("src")
+	engine.GET("/", handler).Name("index")
+	engine.GET("/items/:id<[0-9]+>", handler).Name("item")
+
+	tests := []struct {
+		name   string
+		params []string
+		url    string
+	}{
+		{"index", nil, "/"},
+		{"user_orders", []string{"id", "42"}, "/users/42/orders"},
+		{"user_orders", []string{"id", "a b/c"}, "/users/a%20b%2Fc/orders"},
+		{"src", []string{"filepath", "/css/a b.css"}, "/src/css/a%20b.css"},
+		{"src", []string{"filepath", "js/app.js"}, "/src/js/app.js"},
+		{"item", []string{"id", "5"}, "/items/5"},
+	}
+	for _, test := range tests {
+		url, err := engine.URL(test.name, test.params...)
+		if err != nil {
+			t.Errorf("%s %v: %v", test.name, test.params, err)
+			continue
+		}
+		if url != test.url {
+			t.Errorf("%s %v: got %q, want %q", test.name, test.params, url, test.url)
+		}
+	}
+
+	if _, err := engine.URL("user_orders"); err == nil {
+		t.Error("no error for missing parameter")
+	}
+	if _, err := engine.URL("user_orders", "id"); err == nil {
+		t.Error("no error for odd number of parameters")
+	}
+	if _, err := engine.URL("item", "id", "abc"); err == nil {
+		t.Error("no error for parameter which does not match the constraint")
+	}
+	if _, err := engine.URL("unknown"); err == nil {
+		t.Error("no error for unknown route")
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	var url string
+	engine := New()
+	engine.GET("/users/:id", func(ctx Context) {
+		url, _ = ctx.URLFor("user", "id", "7")
+	}).Name("user")
+
+	performRequest(engine, "GET", "/users/1")
+	if url != "/users/7" {
+		t.Fatalf("got %q", url)
+	}
+}
+
+func handlerForRoutesTest(ctx Context) {}
+
+func TestRoutes(t *testing.T) {
+	middleware := func(ctx Context) {}
+	engine := New()
+	engine.Use(middleware)
+	api := engine.Group("/api")
+	api.GET("/users/:id", middleware, handlerForRoutesTest)
+	api.POST("/users", handlerForRoutesTest)
+	engine.GET("/", handlerForRoutesTest)
+	engine.Host("api.example.com").GET("/", handlerForRoutesTest)
+
+	want := []RouteInfo{
+		{"GET", "/", "github.com/hunyxv/fastweb.handlerForRoutesTest", 1, "", nil},
+		{"POST", "/api/users", "github.com/hunyxv/fastweb.handlerForRoutesTest", 1, "", nil},
+		{"GET", "/api/users/:id", "github.com/hunyxv/fastweb.handlerForRoutesTest", 2, "", nil},
+		{"GET", "/", "github.com/hunyxv/fastweb.handlerForRoutesTest", 1, "api.example.com", nil},
+	}
+	routes := engine.Routes()
+	if len(routes) != len(want) {
+		t.Fatalf("got %d routes, want %d", len(routes), len(want))
+	}
+	for i := range want {
+		got := routes[i]
+		if got.Route == nil || got.Route.Method != got.Method || got.Route.Path != got.Path {
+			t.Errorf("route %d: wrong Route %+v", i, got.Route)
+		}
+		got.Route = nil
+		if got != want[i] {
+			t.Errorf("route %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestContextRoute(t *testing.T) {
+	var route *Route
+	handler := func(ctx Context) {
+		route = ctx.Route()
+	}
+
+	engine := New()
+	api := engine.Group("/api")
+	api.GET("/users/:id", handler).
+		Name("user").
+		Tag("users").
+		Permission("user:read").
+		Describe("get a user").
+		SetMeta("version", 2)
+
+	performRequest(engine, "GET", "/api/users/42")
+	if route == nil {
+		t.Fatal("Route returned nil")
+	}
+	if route.Path != "/api/users/:id" || route.Prefix != "/api" || route.Method != "GET" {
+		t.Errorf("got route %s %s with prefix %s", route.Method, route.Path, route.Prefix)
+	}
+	if route.RouteName() != "user" || route.Description != "get a user" || route.Meta["version"] != 2 {
+		t.Errorf("wrong metadata %+v", route)
+	}
+	if len(route.Tags) != 1 || route.Tags[0] != "users" || len(route.Permissions) != 1 || route.Permissions[0] != "user:read" {
+		t.Errorf("wrong tags %q or permissions %q", route.Tags, route.Permissions)
+	}
+
+	// the route is kept when other routes are removed
+	engine.GET("/other", handler)
+	engine.RemoveRoute("GET", "/other")
+	route = nil
+	performRequest(engine, "HEAD", "/api/users/42")
+	if route == nil || route.RouteName() != "user" {
+		t.Errorf("got route %+v after RemoveRoute", route)
+	}
+
+	var notFound *Route
+	engine.NotFound(func(ctx Context) {
+		notFound = ctx.Route()
+	})
+	performRequest(engine, "GET", "/missing")
+	if notFound != nil {
+		t.Errorf("got route %+v for unmatched request", notFound)
+	}
+}
+
+func TestRouteMetaConcurrent(t *testing.T) {
+	var got *Route
+	engine := New()
+	route := engine.GET("/users/:id", func(ctx Context) {
+		got = ctx.Route()
+		// read the metadata while it is changed
+		_ = len(got.Tags) + len(got.Permissions) + len(got.Meta) + len(got.Description)
+	})
+	engine.GET("/static", func(ctx Context) {}).Tag("static")
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			route.Tag("t"+strconv.Itoa(i)).
+				Permission("p").
+				Describe("d"+strconv.Itoa(i)).
+				SetMeta(strconv.Itoa(i), i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			performRequest(engine, "GET", "/users/42")
+		}
+	}()
+	wg.Wait()
+
+	performRequest(engine, "GET", "/users/42")
+	if len(got.Tags) != 100 || len(got.Permissions) != 100 || len(got.Meta) != 100 || got.Description != "d99" {
+		t.Errorf("got %d tags, %d permissions, %d meta and description %q",
+			len(got.Tags), len(got.Permissions), len(got.Meta), got.Description)
+	}
+
+	// the metadata set through Context.Route changes the registered route
+	got.Tag("seen")
+	for _, info := range engine.Routes() {
+		switch info.Path {
+		case "/users/:id":
+			if len(info.Route.Tags) != 101 || info.Route.Tags[100] != "seen" {
+				t.Errorf("got tags %q for /users/:id", info.Route.Tags)
+			}
+		case "/static":
+			if len(info.Route.Tags) != 1 || info.Route.Tags[0] != "static" {
+				t.Errorf("got tags %q for /static", info.Route.Tags)
+			}
+		}
+	}
+}
+
+func TestRouteSplitNode(t *testing.T) {
+	var got *Route
+	handler := func(ctx Context) {
+		got = ctx.Route()
+	}
+	engine := New()
+	route := engine.GET("/users", handler).Name("users")
+	// splits the node of /users
+	engine.GET("/us", handler)
+	route.Tag("x")
+
+	check := func(step string) {
+		got = nil
+		performRequest(engine, "GET", "/users")
+		if got == nil || got.RouteName() != "users" || len(got.Tags) != 1 || got.Tags[0] != "x" {
+			t.Errorf("%s: got route %+v", step, got)
+		}
+		for _, info := range engine.Routes() {
+			if info.Path == "/users" && (info.Route == nil || len(info.Route.Tags) != 1) {
+				t.Errorf("%s: got route %+v in Routes", step, info.Route)
+			}
+		}
+	}
+	check("after split")
+
+	// RemoveRoute rebuilds the tree from the routes of the nodes
+	engine.GET("/other", handler)
+	engine.RemoveRoute("GET", "/other")
+	check("after RemoveRoute")
+}
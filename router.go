@@ -1,7 +1,11 @@
 package fastweb
 
 import (
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/valyala/fasthttp"
 )
@@ -28,17 +32,46 @@ func (ps Params) ByName(name string) string {
 	return ""
 }
 
+// RedirectPolicy decides how a request is answered whose path matches a
+// route only after it was corrected by RedirectTrailingSlash or
+// RedirectFixedPath.
+type RedirectPolicy uint8
+
+const (
+	// PolicyInherit uses the policy of the parent group, or of the router
+	// for the root group. A router with PolicyInherit redirects.
+	PolicyInherit RedirectPolicy = iota
+
+	// PolicyRedirect redirects the client to the corrected path with status
+	// code 301 for GET requests and 307 for all other request methods.
+	PolicyRedirect
+
+	// PolicyRewrite serves the route of the corrected path directly, the
+	// request path is changed to the corrected path.
+	PolicyRewrite
+
+	// PolicyStrict doesn't correct the path, the request is answered like
+	// any other request without a route, e.g. with 404.
+	PolicyStrict
+)
+
 // Router is a http.Handler which can be used to dispatch requests to different
 // handler functions via configurable routes
 type Router struct {
-	trees                  map[string]*node
+	// The route trees (map[string]*node) by method. A stored map and its
+	// trees are never changed, routes are added to and removed from copies
+	// which then replace the map, so requests are routed without locking.
+	trees atomic.Value
+
+	// mu serializes the writers of trees and groups
+	mu sync.Mutex
 
 	// Enables automatic redirection if the current route can't be matched but a
 	// handler for the path with (without) the trailing slash exists.
 	// For example if /foo/ is requested but a route only exists for /foo, the
 	// client is redirected to /foo with http status code 301 for GET requests
 	// and 307 for all other request methods.
-	RedirectTrailingSlash  bool
+	RedirectTrailingSlash bool
 
 	// If enabled, the router tries to fix the current request path, if no
 	// handle is registered for it.
@@ -49,7 +82,31 @@ type Router struct {
 	// all other request methods.
 	// For example /FOO and /..//Foo could be redirected to /foo.
 	// RedirectTrailingSlash is independent of this option.
-	RedirectFixedPath      bool
+	RedirectFixedPath bool
+
+	// How requests are answered whose path was corrected because of
+	// RedirectTrailingSlash or RedirectFixedPath. The RedirectPolicy of the
+	// group with the longest prefix matching the corrected path takes
+	// priority.
+	RedirectPolicy RedirectPolicy
+
+	// If enabled, the router matches the escaped path of the request URI
+	// instead of the decoded and normalized path, so an escaped '/' (%2F)
+	// doesn't separate path segments. The values of the params are decoded.
+	// Static parts of the routes must be registered in their escaped form.
+	UseRawPath bool
+
+	// If enabled, the router matches the path case-insensitively and serves
+	// the route directly, the values of the params keep the casing of the
+	// request. The setting of the group with the longest prefix matching the
+	// path takes priority.
+	CaseInsensitive bool
+
+	// If enabled, HEAD requests which don't match a HEAD route are handled by
+	// the GET route of the path. The response body is dropped, the
+	// Content-Length header still reports the size of the GET response.
+	// Explicitly registered HEAD routes take priority.
+	HandleHEAD bool
 
 	// If enabled, the router checks if another method is allowed for the
 	// current route, if the current request can not be routed.
@@ -59,36 +116,41 @@ type Router struct {
 	// handler.
 	HandleMethodNotAllowed bool
 
-	// If enabled, the router automatically replies to OPTIONS requests.
+	// If enabled, the router automatically replies to OPTIONS requests with
+	// 204 No Content and the "Allow" header, OPTIONS * lists the methods of
+	// all routes.
 	// Custom OPTIONS handlers take priority over automatic replies.
-	HandleOPTIONS          bool
+	HandleOPTIONS bool
 
 	// An optional http.Handler that is called on automatic OPTIONS requests.
 	// The handler is only called if HandleOPTIONS is true and no OPTIONS
 	// handler for the specific path was set.
 	// The "Allowed" header is set before calling the handler.
-	GlobalOPTIONS          HandlerFunc
-	
-	// Cached value of global (*) allowed methods
-	globalAllowed          string
+	GlobalOPTIONS HandlerFunc
 
 	// Configurable http.Handler which is called when no matching route is
 	// found. If it is not set, http.NotFound is used.
-	NotFound               HandlerFunc
+	NotFound HandlerFunc
 
 	// Configurable http.Handler which is called when a request
 	// cannot be routed and HandleMethodNotAllowed is true.
 	// If it is not set, http.Error with http.StatusMethodNotAllowed is used.
 	// The "Allow" header with allowed request methods is set before the handler
 	// is called.
-	MethodNotAllowed       HandlerFunc
+	MethodNotAllowed HandlerFunc
 
 	// Function to handle panics recovered from http handlers.
 	// It should be used to generate a error page and return the http error code
 	// 500 (Internal Server Error).
 	// The handler can be used to keep your server from crashing because of
 	// unrecovered panics.
-	PanicHandler           HandlerFunc
+	PanicHandler HandlerFunc
+
+	// Groups whose routes are registered on this router. The NotFound,
+	// MethodNotAllowed and PanicHandler of the group with the longest prefix
+	// matching the request path take priority over the handlers above.
+	// []*RouterGroup, copied on write like trees.
+	groups atomic.Value
 }
 
 func newRouter() *Router {
@@ -97,9 +159,113 @@ func newRouter() *Router {
 		RedirectFixedPath:      true,
 		HandleMethodNotAllowed: true,
 		HandleOPTIONS:          true,
+		HandleHEAD:             true,
+	}
+}
+
+// clone returns a router without routes which has the same configuration
+// as r.
+func (r *Router) clone() *Router {
+	return &Router{
+		RedirectTrailingSlash:  r.RedirectTrailingSlash,
+		RedirectFixedPath:      r.RedirectFixedPath,
+		HandleMethodNotAllowed: r.HandleMethodNotAllowed,
+		HandleOPTIONS:          r.HandleOPTIONS,
+		HandleHEAD:             r.HandleHEAD,
+		RedirectPolicy:         r.RedirectPolicy,
+		UseRawPath:             r.UseRawPath,
+		CaseInsensitive:        r.CaseInsensitive,
+		GlobalOPTIONS:          r.GlobalOPTIONS,
+		NotFound:               r.NotFound,
+		MethodNotAllowed:       r.MethodNotAllowed,
+		PanicHandler:           r.PanicHandler,
+	}
+}
+
+// getTrees returns the current route trees, they must not be changed.
+func (r *Router) getTrees() map[string]*node {
+	trees, _ := r.trees.Load().(map[string]*node)
+	return trees
+}
+
+// addGroup registers a group whose routes are added to r.
+func (r *Router) addGroup(group *RouterGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	groups, _ := r.groups.Load().([]*RouterGroup)
+	newGroups := make([]*RouterGroup, len(groups), len(groups)+1)
+	copy(newGroups, groups)
+	r.groups.Store(append(newGroups, group))
+}
+
+// lookupGroup returns the group with the longest prefix matching path. If
+// fold is true, the prefix is compared case-insensitively.
+func (r *Router) lookupGroup(path string, fold bool) *RouterGroup {
+	var group *RouterGroup
+	groups, _ := r.groups.Load().([]*RouterGroup)
+	for _, g := range groups {
+		if group != nil && len(g.prefix) <= len(group.prefix) {
+			continue
+		}
+		if len(path) < len(g.prefix) {
+			continue
+		}
+		if fold {
+			if !strings.EqualFold(path[:len(g.prefix)], g.prefix) {
+				continue
+			}
+		} else if path[:len(g.prefix)] != g.prefix {
+			continue
+		}
+		// the prefix must end at a segment boundary, /api doesn't match /apis
+		if n := len(g.prefix); n == 0 || n == len(path) || g.prefix[n-1] == '/' || path[n] == '/' {
+			group = g
+		}
+	}
+	return group
+}
+
+// groupHandler returns the handler selected by get of the group matching
+// path, the parent groups are searched if the group doesn't define one.
+// If no group defines a handler, h is returned.
+func (r *Router) groupHandler(path string, h HandlerFunc, get func(*RouterGroup) HandlerFunc) HandlerFunc {
+	for g := r.lookupGroup(path, false); g != nil; g = g.parent {
+		if gh := get(g); gh != nil {
+			return gh
+		}
+	}
+	return h
+}
+
+// redirectPolicy returns the policy for the corrected path.
+func (r *Router) redirectPolicy(path string) RedirectPolicy {
+	for g := r.lookupGroup(path, false); g != nil; g = g.parent {
+		if g.redirectPolicy != PolicyInherit {
+			return g.redirectPolicy
+		}
+	}
+	if r.RedirectPolicy != PolicyInherit {
+		return r.RedirectPolicy
 	}
+	return PolicyRedirect
 }
 
+// caseInsensitive reports whether path is matched case-insensitively, the
+// setting of the group matching path takes priority.
+func (r *Router) caseInsensitive(path string) bool {
+	for g := r.lookupGroup(path, true); g != nil; g = g.parent {
+		if g.caseMode != caseInherit {
+			return g.caseMode == caseInsensitive
+		}
+	}
+	return r.CaseInsensitive
+}
+
+func groupNotFound(g *RouterGroup) HandlerFunc         { return g.notFound }
+func groupMethodNotAllowed(g *RouterGroup) HandlerFunc { return g.methodNotAllowed }
+func groupPanicHandler(g *RouterGroup) HandlerFunc     { return g.panicHandler }
+
 // // GET is a shortcut for router.Handle(fasthttp.MethodGet, path, handle)
 // func (r *Router) GET(path string, handle HandlerFunc) {
 // 	r.addRoute(fasthttp.MethodGet, path, handle)
@@ -135,56 +301,177 @@ func newRouter() *Router {
 // 	r.addRoute(fasthttp.MethodDelete, path, handle)
 // }
 
-func (r *Router) addRoute(method, path string, handle HandlerFunc) {
+// addRoute registers the handlers chain with the method and path, route is
+// the registered route returned by Context.Route, it may be nil.
+func (r *Router) addRoute(method, path string, handlers HandlersChain, route *Route) {
 	if len(path) < 1 || path[0] != '/' {
 		panic("path must begin with '/' in path '" + path + "'")
 	}
-
-	if r.trees == nil {
-		r.trees = make(map[string]*node)
+	if len(handlers) == 0 {
+		panic("there must be at least one handler in path '" + path + "'")
 	}
-	root, ok := r.trees[method]
-	if !ok {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The route is added to a copy of the tree, if addRoute panics the
+	// current trees are left untouched.
+	trees := r.getTrees()
+	root := trees[method]
+	if root == nil {
 		root = new(node)
-		r.trees[method] = root
+	} else {
+		root = root.clone()
+	}
+	leaf := root.addRoute(path, handlers)
+	leaf.route = route
+
+	// Nodes of the previous trees are never changed, so the entries copied
+	// from them stay valid even if addRoute cloned or split their copies.
+	if countParams(path) == 0 {
+		if root.static == nil {
+			root.static = make(map[string]*node)
+		}
+		root.static[path] = leaf
+	}
+
+	r.storeTree(trees, method, root)
+}
+
+// removeRoute removes the route registered with the method and the pattern
+// path. It reports whether the route existed.
+func (r *Router) removeRoute(method, path string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	trees := r.getTrees()
+	root := trees[method]
+	if root == nil {
+		return false
+	}
+
+	// rebuild the tree without the route, so no empty nodes are left
+	found := false
+	newRoot := new(node)
+	root.walk(func(n *node) {
+		if n.fullPath == path {
+			found = true
+			return
+		}
+		newRoot.addRoute(n.fullPath, n.handlers).route = n.route
+	})
+	if !found {
+		return false
+	}
+
+	if newRoot.priority == 0 { // no routes left
+		newRoot = nil
+	} else {
+		newRoot.static = newRoot.staticRoutes()
+	}
+	r.storeTree(trees, method, newRoot)
+	return true
+}
 
-		r.globalAllowed = r.allowed("*", "")
+// replaceRoute replaces the route copied from origin in the tree of method
+// with route. The tree is copied on write like in addRoute, so requests
+// reading the previous route are not affected. r.mu must be held.
+func (r *Router) replaceRoute(method string, origin, route *Route) {
+	trees := r.getTrees()
+	root := trees[method]
+	if root == nil {
+		return
+	}
+	root, leaf := root.replaceRoute(origin, route)
+	if root == nil { // the route was removed
+		return
 	}
+	if _, ok := root.static[leaf.fullPath]; ok {
+		root.static[leaf.fullPath] = leaf
+	}
+	r.storeTree(trees, method, root)
+}
 
-	root.addRoute(path, handle)
+// storeTree replaces the trees with a copy of trees in which root is the
+// tree of method. A nil root removes the tree. r.mu must be held.
+func (r *Router) storeTree(trees map[string]*node, method string, root *node) {
+	newTrees := make(map[string]*node, len(trees)+1)
+	for m, t := range trees {
+		newTrees[m] = t
+	}
+	if root != nil {
+		newTrees[method] = root
+	} else {
+		delete(newTrees, method)
+	}
+	r.trees.Store(newTrees)
+}
+
+// routes returns all registered routes, sorted by path and method.
+func (r *Router) routes() []RouteInfo {
+	var routes []RouteInfo
+	for method, root := range r.getTrees() {
+		root.walk(func(n *node) {
+			handler := n.handlers[len(n.handlers)-1]
+			routes = append(routes, RouteInfo{
+				Method:      method,
+				Path:        n.fullPath,
+				Handler:     nameOfFunction(handler),
+				Middlewares: len(n.handlers) - 1,
+				Route:       n.route,
+			})
+		})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
 }
 
 func (r *Router) allowed(path, reqMethod string) (allow string) {
 	allowed := make([]string, 0, 9)
+	hasGet, hasHead := false, false
+	// a path which is matched case-insensitively has the methods of the
+	// corrected path
+	fold := path != "*" && r.caseInsensitive(path)
+
+	for method, root := range r.getTrees() {
+		// Skip the requested method - we already tried this one
+		if method == reqMethod || method == fasthttp.MethodOptions {
+			continue
+		}
 
-	if path == "*" { // server-wide
-		// empty method is used for internal calls to refresh the cache
-		if reqMethod == "" {
-			for method := range r.trees {
-				if method == fasthttp.MethodOptions {
+		if path != "*" { // specific path
+			handlers, _, _ := root.getValue(path)
+			if handlers == nil {
+				if !fold {
+					continue
+				}
+				if _, found := root.findCaseInsensitivePath(path, false); !found {
 					continue
 				}
-				// Add request method to list of allowed methods
-				allowed = append(allowed, method)
 			}
-		} else {
-			return r.globalAllowed
 		}
-	} else { // specific path
-		for method := range r.trees {
-			// Skip the requested method - we already tried this one
-			if method == reqMethod || method == fasthttp.MethodOptions {
-				continue
-			}
 
-			handle, _, _ := r.trees[method].getValue(path)
-			if handle != nil {
-				// Add request method to list of allowed methods
-				allowed = append(allowed, method)
-			}
+		// Add request method to list of allowed methods
+		allowed = append(allowed, method)
+		switch method {
+		case fasthttp.MethodGet:
+			hasGet = true
+		case fasthttp.MethodHead:
+			hasHead = true
 		}
 	}
 
+	// GET routes answer HEAD requests as well, see HandleHEAD
+	if hasGet && !hasHead && r.HandleHEAD && reqMethod != fasthttp.MethodHead {
+		allowed = append(allowed, fasthttp.MethodHead)
+	}
+
 	if len(allowed) > 0 {
 		// Add request method to list of allowed methods
 		allowed = append(allowed, fasthttp.MethodOptions)
@@ -213,8 +500,15 @@ func (r *Router) allowed(path, reqMethod string) (allow string) {
 // of the Router's NotFound handler.
 // To use the operating system's file system implementation,
 // use http.Dir:
-//     router.ServeFiles("/src/*filepath", http.Dir("/var/www"))
+//
+//	router.ServeFiles("/src/*filepath", http.Dir("/var/www"))
 func (r *Router) ServeFiles(path, root string) {
+	r.addRoute(fasthttp.MethodGet, path, HandlersChain{fileHandler(path, root)}, nil)
+}
+
+// fileHandler returns the handler used by ServeFiles. path is the full route
+// path, it is needed to strip the route prefix from the request path.
+func fileHandler(path, root string) HandlerFunc {
 	if len(path) < 10 || path[len(path)-10:] != "/*filepath" {
 		panic("path must end with /*filepath in path '" + path + "'")
 	}
@@ -228,19 +522,19 @@ func (r *Router) ServeFiles(path, root string) {
 		return
 	}(path[:len(path)-10]))
 
-	r.addRoute(fasthttp.MethodGet, path, func(ctx Context) {
+	return func(ctx Context) {
 		fileServer(ctx.GetFctx())
-	})
+	}
 }
 
 func (r *Router) recv(ctx Context) {
 	if rcv := recover(); rcv != nil {
-		if r.PanicHandler != nil {
+		if panicHandler := r.groupHandler(ctx.Path(), r.PanicHandler, groupPanicHandler); panicHandler != nil {
 			ctx.SetUserValue("PanicError", rcv)
-			r.PanicHandler(ctx)
+			panicHandler(ctx)
 		} else {
 			ctx.Error(
-				fasthttp.StatusMessage(fasthttp.StatusInternalServerError), 
+				fasthttp.StatusMessage(fasthttp.StatusInternalServerError),
 				fasthttp.StatusInternalServerError,
 			)
 		}
@@ -249,48 +543,145 @@ func (r *Router) recv(ctx Context) {
 
 // Lookup allows the manual lookup of a method + path combo.
 // This is e.g. useful to build a framework around this router.
-// If the path was found, it returns the handle function and the path parameter
+// If the path was found, it returns the handlers chain and the path parameter
 // values. Otherwise the third return value indicates whether a redirection to
 // the same path with an extra / without the trailing slash should be performed.
-func (r *Router) Lookup(method, path string) (HandlerFunc, Params, bool) {
-	if root := r.trees[method]; root != nil {
+func (r *Router) Lookup(method, path string) (HandlersChain, Params, bool) {
+	if root := r.getTrees()[method]; root != nil {
 		return root.getValue(path)
 	}
 	return nil, nil, false
 }
 
+// serve runs the handlers chain of the matched node, ps are the params of
+// ctx with the wildcard values appended.
+func (r *Router) serve(ctx *context, leaf *node, ps Params) {
+	if r.UseRawPath {
+		// the wildcard values are still escaped, the params of ctx come
+		// first and are already decoded
+		for i := len(ctx.params); i < len(ps); i++ {
+			if value, err := url.PathUnescape(ps[i].Value); err == nil {
+				ps[i].Value = value
+			}
+		}
+	}
+	ctx.params = ps
+	ctx.route = leaf.route
+	ctx.handlers = leaf.handlers
+	ctx.Next()
+}
+
 func (r *Router) Handle(ctx *context) {
 	defer r.recv(ctx)
 
 	path := ctx.Path()
-	if root := r.trees[ctx.Method()]; root != nil {
-		if handle, ps, tsr := root.getValue(path); handle != nil {
-			ctx.SetURLParam(ps)
-			handle(ctx)
-			return
-		} else if ctx.Method() != fasthttp.MethodConnect && path != "/" {
-			code := 301
-			if ctx.Method() != fasthttp.MethodGet {
-				code = 307
+	if r.UseRawPath {
+		path = b2s(ctx.fctx.URI().PathOriginal())
+	}
+	r.handle(ctx, path, true)
+}
+
+// handle routes the request of ctx with path. If fix is false the path is
+// not corrected, it is used to serve a rewritten request.
+func (r *Router) handle(ctx *context, path string, fix bool) {
+	method := ctx.Method()
+	trees := r.getTrees()
+	if method == fasthttp.MethodOptions && b2s(ctx.fctx.RequestURI()) == "*" {
+		// OPTIONS * asks for the capabilities of the server as a whole,
+		// fasthttp reports its path as /*
+		path = "*"
+	} else {
+		root := trees[method]
+		var tsr bool
+		if root != nil {
+			var leaf *node
+			var ps Params
+			if leaf, ps, tsr = root.getNode(path, ctx.paramsFor(root)); leaf != nil {
+				r.serve(ctx, leaf, ps)
+				return
 			}
+		}
+
+		// The GET tree serves HEAD requests without a HEAD route, it is
+		// searched after the HEAD tree by every step below.
+		var get *node
+		if method == fasthttp.MethodHead && r.HandleHEAD {
+			if get = trees[fasthttp.MethodGet]; get != nil {
+				leaf, ps, getTsr := get.getNode(path, ctx.paramsFor(get))
+				if leaf != nil {
+					// fasthttp writes Content-Length but no body
+					ctx.fctx.Response.SkipBody = true
+					r.serve(ctx, leaf, ps)
+					return
+				}
+				tsr = tsr || getTsr
+			}
+		}
+		roots := [...]*node{root, get}
 
+		if r.caseInsensitive(path) {
+			// the static parts are corrected, the values of the params
+			// keep the casing of the request
+			for _, t := range roots {
+				if t == nil {
+					continue
+				}
+				if ciPath, found := t.findCaseInsensitivePath(path, false); found {
+					if leaf, ps, _ := t.getNode(b2s(ciPath), ctx.paramsFor(t)); leaf != nil {
+						if t == get {
+							ctx.fctx.Response.SkipBody = true
+						}
+						r.serve(ctx, leaf, ps)
+						return
+					}
+				}
+			}
+		}
+
+		if fix && (root != nil || get != nil) && method != fasthttp.MethodConnect && path != "/" {
+			var fixedPath string
 			if tsr && r.RedirectTrailingSlash {
 				if len(path) > 1 && path[len(path)-1] == '/' {
-					ctx.SetPath(path[:len(path)-1])
+					fixedPath = path[:len(path)-1]
 				} else {
-					ctx.SetPath(path + "/")
+					fixedPath = path + "/"
+				}
+			} else if r.RedirectFixedPath {
+				for _, t := range roots {
+					if t == nil {
+						continue
+					}
+					ciPath, found := t.findCaseInsensitivePath(
+						CleanPath(path),
+						r.RedirectTrailingSlash,
+					)
+					if found {
+						fixedPath = b2s(ciPath)
+						break
+					}
 				}
-				ctx.fctx.Redirect(ctx.Path(), code)
-				return
 			}
 
-			if r.RedirectFixedPath {
-				fixedPath, found := root.findCaseInsensitivePath(
-					CleanPath(path),
-					r.RedirectTrailingSlash,
-				)
-				if found {
-					ctx.SetPath(b2s(fixedPath))
+			if fixedPath != "" {
+				switch r.redirectPolicy(fixedPath) {
+				case PolicyRewrite:
+					ctx.SetPath(fixedPath)
+					r.handle(ctx, fixedPath, false)
+					return
+				case PolicyStrict:
+					// handled like a path without a route
+				default:
+					code := 301
+					if method != fasthttp.MethodGet {
+						code = 307
+					}
+					if r.UseRawPath {
+						// Redirect would decode the escaped path
+						ctx.SetHeader("Location", fixedPath)
+						ctx.fctx.SetStatusCode(code)
+						return
+					}
+					ctx.SetPath(fixedPath)
 					ctx.Redirect(ctx.Path(), code)
 					return
 				}
@@ -298,25 +689,38 @@ func (r *Router) Handle(ctx *context) {
 		}
 	}
 
-	if ctx.Method() == fasthttp.MethodOptions {
-		if allow := r.allowed(path, ctx.Method()); allow != "" {
+	if method == fasthttp.MethodOptions && r.HandleOPTIONS {
+		// Handle OPTIONS requests
+		if allow := r.allowed(path, fasthttp.MethodOptions); allow != "" {
 			ctx.SetHeader("Allow", allow)
-			if r.MethodNotAllowed != nil {
-				r.MethodNotAllowed(ctx)
+			if r.GlobalOPTIONS != nil {
+				r.GlobalOPTIONS(ctx)
+			} else {
+				ctx.fctx.SetStatusCode(fasthttp.StatusNoContent)
+			}
+			return
+		}
+	} else if r.HandleMethodNotAllowed { // Handle 405
+		if allow := r.allowed(path, method); allow != "" {
+			if methodNotAllowed := r.groupHandler(path, r.MethodNotAllowed, groupMethodNotAllowed); methodNotAllowed != nil {
+				ctx.SetHeader("Allow", allow)
+				methodNotAllowed(ctx)
 			} else {
 				ctx.Error(
-					fasthttp.StatusMessage(fasthttp.StatusMethodNotAllowed), 
+					fasthttp.StatusMessage(fasthttp.StatusMethodNotAllowed),
 					fasthttp.StatusMethodNotAllowed,
 				)
+				// Error resets the response, so the header is set afterwards
+				ctx.SetHeader("Allow", allow)
 			}
 			return
 		}
 	}
 
 	// Handle 404
-	if r.NotFound != nil {
-		r.NotFound(ctx)
-	} else{
+	if notFound := r.groupHandler(path, r.NotFound, groupNotFound); notFound != nil {
+		notFound(ctx)
+	} else {
 		ctx.NotFound()
 	}
 }
@@ -0,0 +1,545 @@
+package fastweb
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRouterMethodNotAllowedAndOPTIONS(t *testing.T) {
+	type flags struct {
+		handleOPTIONS, handleMethodNotAllowed bool
+		globalOPTIONS, methodNotAllowed       bool
+	}
+	type request struct {
+		method, uri string
+		code        int
+		allow       string
+	}
+
+	tests := []struct {
+		flags flags
+		reqs  []request
+	}{
+		{flags{true, true, false, false}, []request{
+			{"POST", "/path", 405, "GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "/path", 204, "GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "*", 204, "DELETE, GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "/custom", 200, ""},
+			{"OPTIONS", "/missing", 404, ""},
+			{"POST", "/missing", 404, ""},
+		}},
+		{flags{true, true, true, true}, []request{
+			{"POST", "/path", 418, "GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "/path", 202, "GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "*", 202, "DELETE, GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "/custom", 200, ""},
+		}},
+		{flags{false, true, false, false}, []request{
+			{"POST", "/path", 405, "GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "/path", 405, "GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "*", 405, "DELETE, GET, HEAD, OPTIONS, PUT"},
+			{"OPTIONS", "/custom", 200, ""},
+		}},
+		{flags{true, false, false, false}, []request{
+			{"POST", "/path", 404, ""},
+			{"OPTIONS", "/path", 204, "GET, HEAD, OPTIONS, PUT"},
+		}},
+		{flags{false, false, true, true}, []request{
+			{"POST", "/path", 404, ""},
+			{"OPTIONS", "/path", 404, ""},
+			{"OPTIONS", "*", 404, ""},
+			{"OPTIONS", "/custom", 200, ""},
+		}},
+	}
+
+	handler := func(ctx Context) {}
+	for _, tt := range tests {
+		engine := New()
+		engine.GET("/path", handler)
+		engine.PUT("/path", handler)
+		engine.DELETE("/other", handler)
+		engine.OPTIONS("/custom", handler)
+
+		r := engine.router
+		r.HandleOPTIONS = tt.flags.handleOPTIONS
+		r.HandleMethodNotAllowed = tt.flags.handleMethodNotAllowed
+		if tt.flags.globalOPTIONS {
+			r.GlobalOPTIONS = func(ctx Context) {
+				ctx.GetFctx().SetStatusCode(fasthttp.StatusAccepted)
+			}
+		}
+		if tt.flags.methodNotAllowed {
+			r.MethodNotAllowed = func(ctx Context) {
+				ctx.GetFctx().SetStatusCode(fasthttp.StatusTeapot)
+			}
+		}
+
+		for _, req := range tt.reqs {
+			fctx := performRequest(engine, req.method, req.uri)
+			if code := fctx.Response.StatusCode(); code != req.code {
+				t.Errorf("%+v %s %s: got status %d, want %d", tt.flags, req.method, req.uri, code, req.code)
+			}
+			if allow := string(fctx.Response.Header.Peek("Allow")); allow != req.allow {
+				t.Errorf("%+v %s %s: got Allow %q, want %q", tt.flags, req.method, req.uri, allow, req.allow)
+			}
+		}
+	}
+}
+
+func TestRouterHandleHEAD(t *testing.T) {
+	engine := New()
+	engine.GET("/report", func(ctx Context) {
+		ctx.SetBodyStrf(200, "report body")
+	})
+	engine.GET("/both", func(ctx Context) {
+		ctx.SetBodyStrf(200, "get")
+	})
+	engine.HEAD("/both", func(ctx Context) {
+		ctx.GetFctx().Response.Header.Set("X-Handler", "head")
+	})
+
+	fctx := performRequest(engine, "HEAD", "/report")
+	if code := fctx.Response.StatusCode(); code != 200 {
+		t.Fatalf("got status %d, want 200", code)
+	}
+	resp := fctx.Response.String()
+	if !strings.Contains(resp, "Content-Length: 11\r\n") {
+		t.Errorf("Content-Length is missing in response:\n%s", resp)
+	}
+	if strings.Contains(resp, "report body") {
+		t.Errorf("body is not dropped:\n%s", resp)
+	}
+
+	fctx = performRequest(engine, "HEAD", "/both")
+	if h := string(fctx.Response.Header.Peek("X-Handler")); h != "head" {
+		t.Errorf("HEAD route is not preferred, got X-Handler %q", h)
+	}
+
+	fctx = performRequest(engine, "HEAD", "/report/")
+	if code := fctx.Response.StatusCode(); code != 307 {
+		t.Errorf("got status %d for trailing slash redirect, want 307", code)
+	}
+
+	// the GET tree is corrected too although a HEAD tree exists
+	fctx = performRequest(engine, "HEAD", "/REPORT")
+	if code, loc := fctx.Response.StatusCode(), string(fctx.Response.Header.Peek("Location")); code != 307 || !strings.HasSuffix(loc, "/report") {
+		t.Errorf("got status %d and Location %q for fixed path redirect, want 307 to /report", code, loc)
+	}
+	engine.router.CaseInsensitive = true
+	fctx = performRequest(engine, "HEAD", "/REPORT")
+	if code := fctx.Response.StatusCode(); code != 200 || strings.Contains(fctx.Response.String(), "report body") {
+		t.Errorf("got status %d for case-insensitive HEAD:\n%s", code, fctx.Response.String())
+	}
+	engine.router.CaseInsensitive = false
+
+	engine.router.HandleHEAD = false
+	fctx = performRequest(engine, "HEAD", "/report")
+	if code := fctx.Response.StatusCode(); code != 405 {
+		t.Errorf("got status %d with HandleHEAD disabled, want 405", code)
+	}
+	if allow := string(fctx.Response.Header.Peek("Allow")); allow != "GET, OPTIONS" {
+		t.Errorf("got Allow %q with HandleHEAD disabled", allow)
+	}
+}
+
+func TestRouterRemoveRoute(t *testing.T) {
+	handler := func(ctx Context) {
+		ctx.SetBodyStrf(200, "%s", ctx.Path())
+	}
+	engine := New()
+	engine.GET("/users/:id", handler).Name("user")
+	engine.GET("/users/:id/orders", handler)
+	engine.POST("/users", handler)
+	api := engine.Group("/api")
+	api.GET("/ping", handler)
+
+	if !engine.RemoveRoute("GET", "/users/:id") {
+		t.Fatal("route /users/:id is not removed")
+	}
+	if engine.RemoveRoute("GET", "/users/:id") {
+		t.Error("removing a removed route reports true")
+	}
+	if _, err := engine.URL("user", "id", "1"); err == nil {
+		t.Error("name of the removed route is kept")
+	}
+	if code := performRequest(engine, "GET", "/users/1").Response.StatusCode(); code != 404 {
+		t.Errorf("got status %d for removed route, want 404", code)
+	}
+	if code := performRequest(engine, "GET", "/users/1/orders").Response.StatusCode(); code != 200 {
+		t.Errorf("got status %d for /users/1/orders, want 200", code)
+	}
+
+	if !api.RemoveRoute("GET", "/ping") {
+		t.Fatal("route /api/ping is not removed")
+	}
+	if !engine.RemoveRoute("POST", "/users") {
+		t.Fatal("route /users is not removed")
+	}
+	if _, ok := engine.router.getTrees()["POST"]; ok {
+		t.Error("empty POST tree is kept")
+	}
+	if routes := engine.Routes(); len(routes) != 1 || routes[0].Path != "/users/:id/orders" {
+		t.Errorf("got routes %+v", routes)
+	}
+}
+
+func TestRouterAddRouteCopyOnWrite(t *testing.T) {
+	handler := func(ctx Context) {}
+	r := newRouter()
+	r.addRoute("GET", "/users/:id/profile", HandlersChain{handler}, nil)
+	old := r.getTrees()["GET"]
+
+	r.addRoute("GET", "/users/new", HandlersChain{handler}, nil)
+	r.addRoute("GET", "/user", HandlersChain{handler}, nil)
+	if handlers, _, _ := old.getValue("/users/new"); handlers != nil {
+		t.Error("old tree is changed by addRoute")
+	}
+	if handlers, _, _ := old.getValue("/user"); handlers != nil {
+		t.Error("old tree is changed by addRoute")
+	}
+
+	// a failed registration leaves the current tree untouched
+	cur := r.getTrees()["GET"]
+	if recv := catchPanic(func() {
+		r.addRoute("GET", "/users/:name/x", HandlersChain{handler}, nil)
+	}); recv == nil {
+		t.Fatal("no panic for conflicting wildcard")
+	}
+	if r.getTrees()["GET"] != cur {
+		t.Error("tree is replaced by a failed addRoute")
+	}
+	if handlers, _, _ := cur.getValue("/users/new"); handlers == nil {
+		t.Error("route /users/new is lost")
+	}
+}
+
+func TestRouterConcurrentChanges(t *testing.T) {
+	handler := func(ctx Context) {}
+	engine := New()
+	engine.GET("/static", handler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := "/plugin" + strconv.Itoa(i) + "/:id"
+			for j := 0; j < 100; j++ {
+				engine.GET(path, handler)
+				engine.RemoveRoute("GET", path)
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if code := performRequest(engine, "GET", "/static").Response.StatusCode(); code != 200 {
+					t.Errorf("got status %d for /static", code)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if routes := engine.Routes(); len(routes) != 1 {
+		t.Errorf("got %d routes, want 1", len(routes))
+	}
+}
+
+var benchRoutes = []string{
+	"/",
+	"/users",
+	"/users/:id",
+	"/users/:id/orders/:order",
+	"/files/:name.:ext",
+	"/static/*filepath",
+}
+
+func newBenchEngine() *Engine {
+	handler := func(ctx Context) {
+		ctx.URLParam("id")
+	}
+	engine := New()
+	for _, route := range benchRoutes {
+		engine.GET(route, handler)
+	}
+	engine.Host(":tenant.example.com").GET("/users/:id", handler)
+	return engine
+}
+
+func newBenchRequest(method, uri string) *fasthttp.RequestCtx {
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod(method)
+	fctx.Request.SetRequestURI(uri)
+	return fctx
+}
+
+var allocRequests = []struct {
+	name, uri string
+}{
+	{"Static", "/users"},
+	{"Param", "/users/42"},
+	{"Params", "/users/42/orders/7"},
+	{"SegmentParams", "/files/readme.md"},
+	{"CatchAll", "/static/css/site.css"},
+	{"HostParam", "http://acme.example.com/users/42"},
+}
+
+func TestRouterZeroAllocs(t *testing.T) {
+	engine := newBenchEngine()
+	for _, req := range allocRequests {
+		fctx := newBenchRequest("GET", req.uri)
+		allocs := testing.AllocsPerRun(100, func() {
+			engine.requestHandler(fctx)
+		})
+		if allocs != 0 {
+			t.Errorf("%s %s: got %v allocs per request, want 0", req.name, req.uri, allocs)
+		}
+	}
+}
+
+func BenchmarkRouter(b *testing.B) {
+	engine := newBenchEngine()
+	for _, req := range allocRequests {
+		b.Run(req.name, func(b *testing.B) {
+			fctx := newBenchRequest("GET", req.uri)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				engine.requestHandler(fctx)
+			}
+		})
+	}
+}
+
+func TestRouterStaticRoutes(t *testing.T) {
+	reply := func(route string) HandlerFunc {
+		return func(ctx Context) {
+			ctx.SetBodyStrf(200, "%s", route)
+		}
+	}
+	// registered in an order which splits the nodes of earlier routes
+	routes := []string{
+		"/abc",
+		"/ab",
+		"/a",
+		"/",
+		"/a/:id",
+		"/abd",
+		"/healthz",
+		"/health",
+		"/api/v1/status",
+		"/api/v1/:name",
+	}
+	engine := New()
+	for _, route := range routes {
+		engine.GET(route, reply(route))
+	}
+	root := engine.router.getTrees()["GET"]
+	if len(root.static) != 8 {
+		t.Errorf("got %d static routes, want 8", len(root.static))
+	}
+
+	check := func(tests map[string]string) {
+		t.Helper()
+		for uri, want := range tests {
+			fctx := performRequest(engine, "GET", uri)
+			if body := string(fctx.Response.Body()); body != want {
+				t.Errorf("GET %s: got %q, want %q", uri, body, want)
+			}
+		}
+	}
+	check(map[string]string{
+		"/abc":           "/abc",
+		"/ab":            "/ab",
+		"/a":             "/a",
+		"/":              "/",
+		"/a/1":           "/a/:id",
+		"/abd":           "/abd",
+		"/healthz":       "/healthz",
+		"/health":        "/health",
+		"/api/v1/status": "/api/v1/status",
+		"/api/v1/users":  "/api/v1/:name",
+	})
+
+	engine.RemoveRoute("GET", "/ab")
+	engine.RemoveRoute("GET", "/api/v1/status")
+	check(map[string]string{
+		"/abc":           "/abc",
+		"/a":             "/a",
+		"/health":        "/health",
+		"/api/v1/status": "/api/v1/:name",
+	})
+	if code := performRequest(engine, "GET", "/ab").Response.StatusCode(); code != 404 {
+		t.Errorf("got status %d for removed route, want 404", code)
+	}
+	// redirects still work for static routes
+	if code := performRequest(engine, "GET", "/HEALTHZ").Response.StatusCode(); code != 301 {
+		t.Errorf("got status %d for fixed path redirect, want 301", code)
+	}
+}
+
+func TestRouterRedirectPolicy(t *testing.T) {
+	handler := func(ctx Context) {
+		ctx.SetBodyStrf(200, "%s %s", ctx.Route().Path, ctx.Path())
+	}
+	engine := New()
+	engine.GET("/other", handler)
+	api := engine.Group("/api").RedirectPolicy(PolicyRewrite)
+	api.GET("/users", handler)
+	api.POST("/users/:id", handler)
+	api.Group("/v1").GET("/status", handler)
+	web := engine.Group("/web").RedirectPolicy(PolicyStrict)
+	web.GET("/page/", handler)
+
+	tests := []struct {
+		method, uri string
+		code        int
+		body        string
+		location    string
+	}{
+		{"GET", "/api/users/", 200, "/api/users /api/users", ""},
+		{"POST", "/api/users/7/", 200, "/api/users/:id /api/users/7", ""},
+		{"GET", "/API/USERS", 200, "/api/users /api/users", ""},
+		{"GET", "/api/v1/status/", 200, "/api/v1/status /api/v1/status", ""},
+		{"GET", "/web/page", 404, "", ""},
+		{"GET", "/WEB/page/", 404, "", ""},
+		{"GET", "/other/", 301, "", "/other"},
+		{"POST", "/other", 405, "", ""},
+	}
+	for _, tt := range tests {
+		fctx := performRequest(engine, tt.method, tt.uri)
+		if code := fctx.Response.StatusCode(); code != tt.code {
+			t.Errorf("%s %s: got status %d, want %d", tt.method, tt.uri, code, tt.code)
+			continue
+		}
+		if tt.body != "" && string(fctx.Response.Body()) != tt.body {
+			t.Errorf("%s %s: got body %q, want %q", tt.method, tt.uri, fctx.Response.Body(), tt.body)
+		}
+		if location := string(fctx.Response.Header.Peek("Location")); !strings.HasSuffix(location, tt.location) {
+			t.Errorf("%s %s: got Location %q, want %q", tt.method, tt.uri, location, tt.location)
+		}
+	}
+
+	engine = New(WithRedirectPolicy(PolicyStrict))
+	engine.GET("/users", handler)
+	engine.Group("/api").RedirectPolicy(PolicyInherit).GET("/users", handler)
+	for _, uri := range []string{"/users/", "/api/users/", "/USERS"} {
+		if code := performRequest(engine, "GET", uri).Response.StatusCode(); code != 404 {
+			t.Errorf("GET %s: got status %d with strict engine policy, want 404", uri, code)
+		}
+	}
+}
+
+func TestRouterUseRawPath(t *testing.T) {
+	handler := func(ctx Context) {
+		key, _ := ctx.URLParam("key")
+		file, _ := ctx.URLParam("file")
+		ctx.GetFctx().SetBodyString(key + "|" + file)
+	}
+	engine := New(WithUseRawPath())
+	engine.GET("/objects/:key", handler)
+	engine.GET("/objects/:key/meta", handler)
+	engine.GET("/files/*file", handler)
+	engine.GET("/a%20b", handler)
+
+	tests := []struct {
+		uri      string
+		code     int
+		body     string
+		location string
+	}{
+		{"/objects/a%2Fb", 200, "a/b|", ""},
+		{"/objects/a%2Fb/meta", 200, "a/b|", ""},
+		{"/objects/plain", 200, "plain|", ""},
+		{"/objects/bad%zz", 200, "bad%zz|", ""},
+		{"/files/dir%2Fx/y%20z", 200, "|/dir/x/y z", ""},
+		{"/a%20b", 200, "|", ""},
+		{"/objects/a%2Fb/", 301, "", "/objects/a%2Fb"},
+	}
+	for _, tt := range tests {
+		fctx := performRequest(engine, "GET", tt.uri)
+		if code := fctx.Response.StatusCode(); code != tt.code {
+			t.Errorf("GET %s: got status %d, want %d", tt.uri, code, tt.code)
+			continue
+		}
+		if tt.body != "" && string(fctx.Response.Body()) != tt.body {
+			t.Errorf("GET %s: got body %q, want %q", tt.uri, fctx.Response.Body(), tt.body)
+		}
+		if location := string(fctx.Response.Header.Peek("Location")); location != tt.location {
+			t.Errorf("GET %s: got Location %q, want %q", tt.uri, location, tt.location)
+		}
+	}
+
+	// the decoded path is used by default
+	engine = New()
+	engine.GET("/objects/:key", handler)
+	if code := performRequest(engine, "GET", "/objects/a%2Fb").Response.StatusCode(); code != 404 {
+		t.Errorf("got status %d for escaped slash without UseRawPath, want 404", code)
+	}
+}
+
+func TestRouterCaseInsensitive(t *testing.T) {
+	handler := func(ctx Context) {
+		name, _ := ctx.URLParam("name")
+		ctx.GetFctx().SetBodyString(ctx.Route().Path + "|" + name)
+	}
+	engine := New()
+	engine.GET("/home", handler)
+	api := engine.Group("/api").CaseInsensitive(true)
+	api.GET("/users/:name", handler)
+	api.GET("/Files/*name", handler)
+	api.Group("/strict").CaseInsensitive(false).GET("/ping", handler)
+
+	tests := []struct {
+		uri  string
+		code int
+		body string
+	}{
+		{"/API/Users/JohnDoe", 200, "/api/users/:name|JohnDoe"},
+		{"/api/USERS/x", 200, "/api/users/:name|x"},
+		{"/Api/files/Dir/A.TXT", 200, "/api/Files/*name|/Dir/A.TXT"},
+		{"/API/STRICT/PING", 301, ""},
+		{"/HOME", 301, ""},
+	}
+	for _, tt := range tests {
+		fctx := performRequest(engine, "GET", tt.uri)
+		if code := fctx.Response.StatusCode(); code != tt.code {
+			t.Errorf("GET %s: got status %d, want %d", tt.uri, code, tt.code)
+			continue
+		}
+		if tt.body != "" && string(fctx.Response.Body()) != tt.body {
+			t.Errorf("GET %s: got body %q, want %q", tt.uri, fctx.Response.Body(), tt.body)
+		}
+	}
+
+	// 405 and OPTIONS use the methods of the case-corrected path
+	fctx := performRequest(engine, "POST", "/API/Users/Bob")
+	if code, allow := fctx.Response.StatusCode(), string(fctx.Response.Header.Peek("Allow")); code != 405 || allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("POST /API/Users/Bob: got status %d, Allow %q", code, allow)
+	}
+	fctx = performRequest(engine, "OPTIONS", "/API/Users/Bob")
+	if code, allow := fctx.Response.StatusCode(), string(fctx.Response.Header.Peek("Allow")); code != 204 || allow != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS /API/Users/Bob: got status %d, Allow %q", code, allow)
+	}
+	if code := performRequest(engine, "POST", "/HOME").Response.StatusCode(); code != 404 {
+		t.Errorf("POST /HOME: got status %d outside the case-insensitive group, want 404", code)
+	}
+
+	engine = New(WithCaseInsensitive())
+	engine.GET("/home", handler)
+	fctx = performRequest(engine, "HEAD", "/HOME")
+	if code := fctx.Response.StatusCode(); code != 200 || !fctx.Response.SkipBody {
+		t.Errorf("HEAD /HOME: got status %d, skip body %t", code, fctx.Response.SkipBody)
+	}
+	if code := performRequest(engine, "GET", "/HOME/").Response.StatusCode(); code != 301 {
+		t.Errorf("GET /HOME/: got status %d, want trailing slash redirect", code)
+	}
+}
@@ -6,8 +6,6 @@ package fastweb
 
 import (
 	"strings"
-	"unicode"
-	"unicode/utf8"
 )
 
 func min(a, b int) int {
@@ -26,6 +24,13 @@ func countParams(path string) uint8 {
 			continue
 		}
 		n++
+
+		// skip the wildcard, its constraint may contain ':' or '*'
+		end := wildcardEnd(path, i)
+		if end < 0 {
+			break
+		}
+		i = end - 1
 	}
 	if n >= uint(maxParamCount) {
 		return maxParamCount
@@ -43,15 +48,61 @@ const (
 	catchAll
 )
 
+// A node has static children, which are indexed by the first byte of their
+// path, and at most one param child and one catchAll child. While matching a
+// request the static children are tried first, then the param child and at
+// last the catchAll child, so e.g. /users/new and /users/:id can be
+// registered together.
 type node struct {
-	path      string
-	wildChild bool
-	nType     nodeType
-	maxParams uint8
-	priority  uint32
-	indices   string
-	children  []*node
-	handle    HandlerFunc
+	path          string
+	nType         nodeType
+	maxParams     uint8
+	priority      uint32
+	indices       string
+	children      []*node // static children
+	paramChild    *node
+	catchAllChild *node
+	handlers      HandlersChain
+	fullPath      string // the registered pattern, only set on nodes with handlers
+	route         *Route // the route registered by the RouterGroup, may be nil
+
+	// exact-match map of the routes without wildcards, only used on the
+	// root node. An entry may be an older copy of the node in the tree, see
+	// Router.addRoute, it has the same handlers and route.
+	static map[string]*node
+
+	// constraint of a param node, the path of the node ends with its expr
+	constraint *constraint
+
+	// a static child of the param node starts with a literal instead of '/',
+	// the value of the param may end in the middle of the path segment
+	literalChild bool
+}
+
+// paramKey returns the name of a param node without its constraint.
+func (n *node) paramKey() string {
+	if n.constraint != nil {
+		return n.path[1 : len(n.path)-len(n.constraint.expr)]
+	}
+	return n.path[1:]
+}
+
+// clone returns a copy of n with its own children slice. Trees are copied
+// on write, see Router.addRoute: every node on the way to the changed node
+// is cloned, all other nodes are shared with the previous tree.
+func (n *node) clone() *node {
+	c := *n
+	if len(n.children) > 0 {
+		c.children = make([]*node, len(n.children))
+		copy(c.children, n.children)
+	}
+	if n.static != nil {
+		c.static = make(map[string]*node, len(n.static)+1)
+		for path, leaf := range n.static {
+			c.static[path] = leaf
+		}
+	}
+	return &c
 }
 
 // increments priority of the given child and reorders if necessary
@@ -78,385 +129,497 @@ func (n *node) incrementChildPrio(pos int) int {
 	return newPos
 }
 
-// addRoute adds a node with the given handle to the path.
-// Not concurrency-safe!
-func (n *node) addRoute(path string, handle HandlerFunc) {
+// addRoute adds a node with the given handlers chain to the path and returns
+// the node.
+// The nodes below n are cloned before they are changed, but n itself is
+// changed in place, so it must not be visible to readers.
+func (n *node) addRoute(path string, handlers HandlersChain) *node {
 	fullPath := path
-	n.priority++
 	numParams := countParams(path)
 
-	// non-empty tree
-	if len(n.path) > 0 || len(n.children) > 0 {
-	walk:
-		for {
-			// Update maxParams of the current node
+	n.nType = root
+	n.priority++
+	if numParams > n.maxParams {
+		n.maxParams = numParams
+	}
+
+	// The root node holds the longest common prefix of all routes, the split
+	// must not take the '/' of a catchAll
+	prefix := path
+	if i := strings.IndexAny(path, ":*"); i >= 0 {
+		if path[i] == '*' && i > 0 {
+			i--
+		}
+		prefix = path[:i]
+	}
+	if n.priority == 1 { // Empty tree
+		n.path = prefix
+	} else {
+		i := 0
+		max := min(len(prefix), len(n.path))
+		for i < max && prefix[i] == n.path[i] {
+			i++
+		}
+		if i < len(n.path) {
+			n.split(i)
+		}
+	}
+	path = path[len(n.path):]
+
+	for len(path) > 0 {
+		// find the beginning of the next wildcard, a catchAll owns the '/'
+		// in front of it
+		i := strings.IndexAny(path, ":*")
+		if i < 0 {
+			n = n.addStatic(path, numParams)
+			break
+		}
+		if path[i] == '*' {
+			if i == 0 || path[i-1] != '/' {
+				panic("no / before catch-all in path '" + fullPath + "'")
+			}
+			i--
+		}
+		if i > 0 {
+			n = n.addStatic(path[:i], numParams)
+			path = path[i:]
+		}
+
+		if path[0] == ':' { // param
+			// find wildcard end, the wildcard name may be followed by a
+			// constraint and a literal, e.g. :name<[a-z]+>.:ext
+			end := wildcardEnd(path, 0)
+			if end < 0 {
+				panic("unterminated constraint '" + path + "' in path '" + fullPath + "'")
+			}
+			if end < len(path) && path[end] == ':' {
+				// the value of the first param could end anywhere
+				panic("params in one path segment must be separated by a literal, has: '" +
+					path + "' in path '" + fullPath + "'")
+			}
+
+			n = n.addParam(path[:end], fullPath, numParams)
+			numParams--
+			path = path[end:]
+			continue
+		}
+
+		// catchAll
+		if end := wildcardEnd(path, 1); end != len(path) {
+			panic("catch-all routes are only allowed at the end of the path in path '" + fullPath + "'")
+		}
+		n = n.addCatchAll(path, fullPath)
+		break
+	}
+
+	if n.handlers != nil {
+		panic("a handle is already registered for path '" + fullPath + "'")
+	}
+	n.handlers = handlers
+	n.fullPath = fullPath
+	return n
+}
+
+// split splits the path of n at i. The rest of the path, the children and
+// the handlers and route of n are moved to a new static child.
+func (n *node) split(i int) {
+	child := &node{
+		path:          n.path[i:],
+		nType:         static,
+		maxParams:     n.maxParams,
+		indices:       n.indices,
+		children:      n.children,
+		paramChild:    n.paramChild,
+		catchAllChild: n.catchAllChild,
+		handlers:      n.handlers,
+		fullPath:      n.fullPath,
+		route:         n.route,
+		priority:      n.priority - 1,
+	}
+
+	n.children = []*node{child}
+	// []byte for proper unicode char conversion, see #65
+	n.indices = string([]byte{n.path[i]})
+	n.path = n.path[:i]
+	n.paramChild = nil
+	n.catchAllChild = nil
+	n.handlers = nil
+	n.fullPath = ""
+	n.route = nil
+}
+
+// addStatic inserts the static path below n and returns the node the path
+// ends with. Existing nodes are split where necessary.
+func (n *node) addStatic(path string, numParams uint8) *node {
+walk:
+	for {
+		c := path[0]
+
+		// Check if a child with the next path byte exists
+		for i := 0; i < len(n.indices); i++ {
+			if c != n.indices[i] {
+				continue
+			}
+
+			n.children[i] = n.children[i].clone()
+			i = n.incrementChildPrio(i)
+			n = n.children[i]
 			if numParams > n.maxParams {
 				n.maxParams = numParams
 			}
 
 			// Find the longest common prefix.
-			// This also implies that the common prefix contains no ':' or '*'
-			// since the existing key can't contain those chars.
-			i := 0
+			j := 0
 			max := min(len(path), len(n.path))
-			for i < max && path[i] == n.path[i] {
-				i++
+			for j < max && path[j] == n.path[j] {
+				j++
 			}
 
 			// Split edge
-			if i < len(n.path) {
-				child := node{
-					path:      n.path[i:],
-					wildChild: n.wildChild,
-					nType:     static,
-					indices:   n.indices,
-					children:  n.children,
-					handle:    n.handle,
-					priority:  n.priority - 1,
-				}
-
-				// Update maxParams (max of all children)
-				for i := range child.children {
-					if child.children[i].maxParams > child.maxParams {
-						child.maxParams = child.children[i].maxParams
-					}
-				}
-
-				n.children = []*node{&child}
-				// []byte for proper unicode char conversion, see #65
-				n.indices = string([]byte{n.path[i]})
-				n.path = path[:i]
-				n.handle = nil
-				n.wildChild = false
+			if j < len(n.path) {
+				n.split(j)
 			}
 
-			// Make new node a child of this node
-			if i < len(path) {
-				path = path[i:]
-
-				if n.wildChild {
-					n = n.children[0]
-					n.priority++
-
-					// Update maxParams of the child node
-					if numParams > n.maxParams {
-						n.maxParams = numParams
-					}
-					numParams--
-
-					// Check if the wildcard matches
-					if len(path) >= len(n.path) && n.path == path[:len(n.path)] &&
-						// Adding a child to a catchAll is not possible
-						n.nType != catchAll &&
-						// Check for longer wildcard, e.g. :name and :names
-						(len(n.path) >= len(path) || path[len(n.path)] == '/') {
-						continue walk
-					} else {
-						// Wildcard conflict
-						var pathSeg string
-						if n.nType == catchAll {
-							pathSeg = path
-						} else {
-							pathSeg = strings.SplitN(path, "/", 2)[0]
-						}
-						prefix := fullPath[:strings.Index(fullPath, pathSeg)] + n.path
-						panic("'" + pathSeg +
-							"' in new path '" + fullPath +
-							"' conflicts with existing wildcard '" + n.path +
-							"' in existing prefix '" + prefix +
-							"'")
-					}
-				}
+			if j == len(path) {
+				return n
+			}
+			path = path[j:]
+			continue walk
+		}
 
-				c := path[0]
+		// Otherwise insert it
+		// []byte for proper unicode char conversion, see #65
+		n.indices += string([]byte{c})
+		if n.nType == param && c != '/' {
+			n.literalChild = true
+		}
+		child := &node{
+			path:      path,
+			maxParams: numParams,
+		}
+		n.children = append(n.children, child)
+		n.incrementChildPrio(len(n.indices) - 1)
+		return child
+	}
+}
 
-				// slash after param
-				if n.nType == param && c == '/' && len(n.children) == 1 {
-					n = n.children[0]
-					n.priority++
-					continue walk
-				}
+// addParam returns the param child of n, it is created if necessary.
+// A node can only have one param child, so the wildcard must be the same as
+// the one already registered.
+func (n *node) addParam(wildcard, fullPath string, numParams uint8) *node {
+	name, expr := splitWildcard(wildcard)
+	if len(name) == 0 {
+		panic("wildcards must be named with a non-empty name in path '" + fullPath + "'")
+	}
 
-				// Check if a child with the next path byte exists
-				for i := 0; i < len(n.indices); i++ {
-					if c == n.indices[i] {
-						i = n.incrementChildPrio(i)
-						n = n.children[i]
-						continue walk
-					}
-				}
+	if child := n.paramChild; child != nil {
+		if child.path != wildcard {
+			if oldName, oldExpr := splitWildcard(child.path); name == oldName {
+				panic("constraint '" + expr + "' of ':" + name +
+					"' in new path '" + fullPath +
+					"' conflicts with existing constraint '" + oldExpr +
+					"' of wildcard '" + child.path + "'")
+			}
+			panic("'" + wildcard +
+				"' in new path '" + fullPath +
+				"' conflicts with existing wildcard '" + child.path +
+				"' in existing prefix '" + fullPath[:strings.Index(fullPath, wildcard)] + child.path +
+				"'")
+		}
 
-				// Otherwise insert it
-				if c != ':' && c != '*' {
-					// []byte for proper unicode char conversion, see #65
-					n.indices += string([]byte{c})
-					child := &node{
-						maxParams: numParams,
-					}
-					n.children = append(n.children, child)
-					n.incrementChildPrio(len(n.indices) - 1)
-					n = child
-				}
-				n.insertChild(numParams, path, fullPath, handle)
-				return
+		child = child.clone()
+		child.priority++
+		if numParams > child.maxParams {
+			child.maxParams = numParams
+		}
+		n.paramChild = child
+		return child
+	}
 
-			} else if i == len(path) { // Make node a (in-path) leaf
-				if n.handle != nil {
-					panic("a handle is already registered for path '" + fullPath + "'")
-				}
-				n.handle = handle
-			}
-			return
+	child := &node{
+		path:      wildcard,
+		nType:     param,
+		maxParams: numParams,
+		priority:  1,
+	}
+	if len(expr) > 0 {
+		c, err := compileConstraint(expr)
+		if err != nil {
+			panic(err.Error() + " in path '" + fullPath + "'")
 		}
-	} else { // Empty tree
-		n.insertChild(numParams, path, fullPath, handle)
-		n.nType = root
+		child.constraint = c
 	}
+	n.paramChild = child
+	return child
 }
 
-func (n *node) insertChild(numParams uint8, path, fullPath string, handle HandlerFunc) {
-	var offset int // already handled bytes of the path
+// addCatchAll returns the catchAll child of n, it is created if necessary.
+func (n *node) addCatchAll(wildcard, fullPath string) *node {
+	if len(wildcard) < 3 {
+		panic("wildcards must be named with a non-empty name in path '" + fullPath + "'")
+	}
 
-	// find prefix until first wildcard (beginning with ':'' or '*'')
-	for i, max := 0, len(path); numParams > 0; i++ {
-		c := path[i]
-		if c != ':' && c != '*' {
-			continue
+	if child := n.catchAllChild; child != nil {
+		if child.path != wildcard {
+			panic("'" + wildcard +
+				"' in new path '" + fullPath +
+				"' conflicts with existing wildcard '" + child.path +
+				"' in existing prefix '" + fullPath[:strings.LastIndex(fullPath, wildcard)] + child.path +
+				"'")
 		}
+		child = child.clone()
+		n.catchAllChild = child
+		return child
+	}
 
-		// find wildcard end (either '/' or path end)
-		end := i + 1
-		for end < max && path[end] != '/' {
-			switch path[end] {
-			// the wildcard name must not contain ':' and '*'
-			case ':', '*':
-				panic("only one wildcard per path segment is allowed, has: '" +
-					path[i:] + "' in path '" + fullPath + "'")
-			default:
-				end++
-			}
-		}
+	child := &node{
+		path:      wildcard,
+		nType:     catchAll,
+		maxParams: 1,
+		priority:  1,
+	}
+	n.catchAllChild = child
+	return child
+}
 
-		// check if this Node existing children which would be
-		// unreachable if we insert the wildcard here
-		if len(n.children) > 0 {
-			panic("wildcard route '" + path[i:end] +
-				"' conflicts with existing children in path '" + fullPath + "'")
+// staticRoutes returns the exact-match map of the routes without wildcards
+// in the tree.
+func (n *node) staticRoutes() map[string]*node {
+	static := make(map[string]*node)
+	n.walk(func(leaf *node) {
+		if countParams(leaf.fullPath) == 0 {
+			static[leaf.fullPath] = leaf
 		}
+	})
+	return static
+}
+
+// walk calls fn for every node in the tree that has handlers registered.
+func (n *node) walk(fn func(n *node)) {
+	if n.handlers != nil {
+		fn(n)
+	}
+	for _, child := range n.children {
+		child.walk(fn)
+	}
+	if n.paramChild != nil {
+		n.paramChild.walk(fn)
+	}
+	if n.catchAllChild != nil {
+		n.catchAllChild.walk(fn)
+	}
+}
 
-		// check if the wildcard has a name
-		if end-i < 2 {
-			panic("wildcards must be named with a non-empty name in path '" + fullPath + "'")
+// replaceRoute returns a copy of n in which the leaf holding the route copied
+// from origin holds route instead, and the new leaf. Only the nodes on the way
+// to the leaf are cloned. It returns nil if no leaf holds such a route.
+func (n *node) replaceRoute(origin, route *Route) (*node, *node) {
+	if n.route != nil && n.route.origin == origin {
+		c := n.clone()
+		c.route = route
+		return c, c
+	}
+	for i, child := range n.children {
+		if newChild, leaf := child.replaceRoute(origin, route); newChild != nil {
+			c := n.clone()
+			c.children[i] = newChild
+			return c, leaf
 		}
+	}
+	if n.paramChild != nil {
+		if newChild, leaf := n.paramChild.replaceRoute(origin, route); newChild != nil {
+			c := n.clone()
+			c.paramChild = newChild
+			return c, leaf
+		}
+	}
+	if n.catchAllChild != nil {
+		if newChild, leaf := n.catchAllChild.replaceRoute(origin, route); newChild != nil {
+			c := n.clone()
+			c.catchAllChild = newChild
+			return c, leaf
+		}
+	}
+	return nil, nil
+}
 
-		if c == ':' { // param
-			// split path at the beginning of the wildcard
-			if i > 0 {
-				n.path = path[offset:i]
-				offset = i
-			}
+// Returns the handlers chain registered with the given path (key). The values of
+// wildcards are saved to a slice.
+// If no handle can be found, a TSR (trailing slash redirect) recommendation is
+// made if a handle exists with an extra (without the) trailing slash for the
+// given path.
+func (n *node) getValue(path string) (handlers HandlersChain, p Params, tsr bool) {
+	leaf, p, tsr := n.getNode(path, nil)
+	if leaf != nil {
+		return leaf.handlers, p, false
+	}
+	return nil, nil, tsr
+}
 
-			child := &node{
-				nType:     param,
-				maxParams: numParams,
-			}
-			n.children = []*node{child}
-			n.wildChild = true
-			n = child
-			n.priority++
-			numParams--
+// getNode is like getValue, but returns the node with the handlers. The
+// values of wildcards are appended to ps, they don't cause allocations if
+// the capacity of ps is enough for n.maxParams more params.
+func (n *node) getNode(path string, ps Params) (leaf *node, p Params, tsr bool) {
+	// a static route is always preferred by the tree walk too
+	if n.static != nil {
+		if leaf := n.static[path]; leaf != nil {
+			return leaf, ps, false
+		}
+	}
+	prefix := n.path
+	if len(path) >= len(prefix) && path[:len(prefix)] == prefix {
+		if leaf, p := n.findChild(path[len(prefix):], ps); leaf != nil {
+			return leaf, p, false
+		}
+	}
 
-			// if the path doesn't end with the wildcard, then there
-			// will be another non-wildcard subpath starting with '/'
-			if end < max {
-				n.path = path[offset:end]
-				offset = end
+	// Nothing found. We can recommend to redirect to the same URL with (without)
+	// a trailing slash if a leaf exists for that path.
+	if len(path) > 1 && path[len(path)-1] == '/' {
+		leaf, _ := n.find(path[:len(path)-1], nil)
+		tsr = leaf != nil
+	} else if len(path) > 0 {
+		leaf, _ := n.find(path+"/", nil)
+		tsr = leaf != nil
+	}
+	return nil, nil, tsr
+}
 
-				child := &node{
-					maxParams: numParams,
-					priority:  1,
-				}
-				n.children = []*node{child}
-				n = child
-			}
+// find returns the node with handlers which matches path, path starts with the
+// path of the static node n. The values of wildcards are appended to p.
+func (n *node) find(path string, p Params) (*node, Params) {
+	prefix := n.path
+	if len(path) < len(prefix) || path[:len(prefix)] != prefix {
+		return nil, p
+	}
+	return n.findChild(path[len(prefix):], p)
+}
 
-		} else { // catchAll
-			if end != max || numParams > 1 {
-				panic("catch-all routes are only allowed at the end of the path in path '" + fullPath + "'")
+// findChild returns the node with handlers which matches path, the part of
+// the request path after n. Static children are preferred, if a static branch
+// doesn't match the walk backtracks into the param and catchAll children.
+func (n *node) findChild(path string, p Params) (*node, Params) {
+walk: // outer loop for walking the tree
+	for {
+		if len(path) == 0 {
+			if n.handlers != nil {
+				return n, p
 			}
+			return nil, p
+		}
 
-			if len(n.path) > 0 && n.path[len(n.path)-1] == '/' {
-				panic("catch-all conflicts with existing handle for the path segment root in path '" + fullPath + "'")
+		c := path[0]
+		for i := 0; i < len(n.indices); i++ {
+			if c == n.indices[i] {
+				child := n.children[i]
+				prefix := child.path
+				if len(path) < len(prefix) || path[:len(prefix)] != prefix {
+					break
+				}
+				if n.paramChild == nil && n.catchAllChild == nil {
+					// If this node does not have a wildcard (param or
+					// catchAll) child, there is nothing to backtrack to and
+					// we can just continue to walk down the tree
+					path = path[len(prefix):]
+					n = child
+					continue walk
+				}
+				// p is passed by value, a failed branch can't change it
+				if leaf, ps := child.findChild(path[len(prefix):], p); leaf != nil {
+					return leaf, ps
+				}
+				break
 			}
+		}
 
-			// currently fixed width 1 for '/'
-			i--
-			if path[i] != '/' {
-				panic("no / before catch-all in path '" + fullPath + "'")
-			}
+		// The param child can be walked without backtracking if its value
+		// runs to the end of the segment and there is no catchAll child.
+		child := n.paramChild
+		if child == nil || child.literalChild || n.catchAllChild != nil {
+			return n.findWildcard(path, p)
+		}
 
-			n.path = path[offset:i]
+		// find param end (either '/' or path end)
+		end := 0
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
 
-			// first node: catchAll node with empty path
-			child := &node{
-				wildChild: true,
-				nType:     catchAll,
-				maxParams: 1,
-			}
-			// update maxParams of the parent node
-			if n.maxParams < 1 {
-				n.maxParams = 1
-			}
-			n.children = []*node{child}
-			n.indices = string(path[i])
-			n = child
-			n.priority++
-
-			// second node: node holding the variable
-			child = &node{
-				path:      path[i:],
-				nType:     catchAll,
-				maxParams: 1,
-				handle:    handle,
-				priority:  1,
-			}
-			n.children = []*node{child}
+		// a value which fails the constraint does not match
+		if end == 0 || (child.constraint != nil && !child.constraint.match(path[:end])) {
+			return nil, p
+		}
 
-			return
+		// save param value
+		if p == nil {
+			// lazy allocation
+			p = make(Params, 0, child.maxParams)
 		}
+		i := len(p)
+		p = p[:i+1] // expand slice within preallocated capacity
+		p[i].Key = child.paramKey()
+		p[i].Value = path[:end]
+
+		// we need to go deeper!
+		path = path[end:]
+		n = child
 	}
-
-	// insert remaining path part and handle to the leaf
-	n.path = path[offset:]
-	n.handle = handle
 }
 
-// Returns the handle registered with the given path (key). The values of
-// wildcards are saved to a map.
-// If no handle can be found, a TSR (trailing slash redirect) recommendation is
-// made if a handle exists with an extra (without the) trailing slash for the
-// given path.
-func (n *node) getValue(path string) (handle HandlerFunc, p Params, tsr bool) {
-walk: // outer loop for walking the tree
-	for {
-		if len(path) > len(n.path) {
-			if path[:len(n.path)] == n.path {
-				path = path[len(n.path):]
-				// If this node does not have a wildcard (param or catchAll)
-				// child,  we can just look up the next child node and continue
-				// to walk down the tree
-				if !n.wildChild {
-					c := path[0]
-					for i := 0; i < len(n.indices); i++ {
-						if c == n.indices[i] {
-							n = n.children[i]
-							continue walk
-						}
-					}
-
-					// Nothing found.
-					// We can recommend to redirect to the same URL without a
-					// trailing slash if a leaf exists for that path.
-					tsr = (path == "/" && n.handle != nil)
-					return
+// findWildcard returns the node with handlers which matches path in the param
+// or the catchAll child of n. It is the slow path of findChild, the value of
+// the param child may end in the middle of the segment and the walk can
+// backtrack into the catchAll child.
+func (n *node) findWildcard(path string, p Params) (*node, Params) {
+	if child := n.paramChild; child != nil {
+		// find param end (either '/' or path end)
+		end := 0
+		for end < len(path) && path[end] != '/' {
+			end++
+		}
 
+		// The value can also end in the middle of the segment, where the
+		// literal of a static child starts, e.g. :name.:ext. The shortest
+		// value is tried first.
+		if child.literalChild {
+			for k := 1; k < end; k++ {
+				if strings.IndexByte(child.indices, path[k]) < 0 ||
+					(child.constraint != nil && !child.constraint.match(path[:k])) {
+					continue
 				}
-
-				// handle wildcard child
-				n = n.children[0]
-				switch n.nType {
-				case param:
-					// find param end (either '/' or path end)
-					end := 0
-					for end < len(path) && path[end] != '/' {
-						end++
-					}
-
-					// save param value
-					if p == nil {
-						// lazy allocation
-						p = make(Params, 0, n.maxParams)
-					}
-					i := len(p)
-					p = p[:i+1] // expand slice within preallocated capacity
-					p[i].Key = n.path[1:]
-					p[i].Value = path[:end]
-
-					// we need to go deeper!
-					if end < len(path) {
-						if len(n.children) > 0 {
-							path = path[end:]
-							n = n.children[0]
-							continue walk
-						}
-
-						// ... but we can't
-						tsr = (len(path) == end+1)
-						return
-					}
-
-					if handle = n.handle; handle != nil {
-						return
-					} else if len(n.children) == 1 {
-						// No handle found. Check if a handle for this path + a
-						// trailing slash exists for TSR recommendation
-						n = n.children[0]
-						tsr = (n.path == "/" && n.handle != nil)
-					}
-
-					return
-
-				case catchAll:
-					// save param value
-					if p == nil {
-						// lazy allocation
-						p = make(Params, 0, n.maxParams)
-					}
-					i := len(p)
-					p = p[:i+1] // expand slice within preallocated capacity
-					p[i].Key = n.path[2:]
-					p[i].Value = path
-
-					handle = n.handle
-					return
-
-				default:
-					panic("invalid node type")
+				if p == nil {
+					// lazy allocation
+					p = make(Params, 0, child.maxParams)
+				}
+				ps := append(p, Param{Key: child.paramKey(), Value: path[:k]})
+				if leaf, ps := child.findChild(path[k:], ps); leaf != nil {
+					return leaf, ps
 				}
 			}
-		} else if path == n.path {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if handle = n.handle; handle != nil {
-				return
-			}
+		}
 
-			if path == "/" && n.wildChild && n.nType != root {
-				tsr = true
-				return
+		// a value which fails the constraint does not match
+		if end > 0 && (child.constraint == nil || child.constraint.match(path[:end])) {
+			if p == nil {
+				// lazy allocation
+				p = make(Params, 0, child.maxParams)
 			}
-
-			// No handle found. Check if a handle for this path + a
-			// trailing slash exists for trailing slash recommendation
-			for i := 0; i < len(n.indices); i++ {
-				if n.indices[i] == '/' {
-					n = n.children[i]
-					tsr = (len(n.path) == 1 && n.handle != nil) ||
-						(n.nType == catchAll && n.children[0].handle != nil)
-					return
-				}
+			ps := append(p, Param{Key: child.paramKey(), Value: path[:end]})
+			if leaf, ps := child.findChild(path[end:], ps); leaf != nil {
+				return leaf, ps
 			}
-
-			return
 		}
+	}
 
-		// Nothing found. We can recommend to redirect to the same URL with an
-		// extra trailing slash if a leaf exists for that path
-		tsr = (path == "/") ||
-			(len(n.path) == len(path)+1 && n.path[len(path)] == '/' &&
-				path == n.path[:len(n.path)-1] && n.handle != nil)
-		return
+	// handle catchAll child
+	if child := n.catchAllChild; child != nil && path[0] == '/' {
+		if p == nil {
+			// lazy allocation
+			p = make(Params, 0, child.maxParams)
+		}
+		return child, append(p, Param{Key: child.path[2:], Value: path})
 	}
+	return nil, p
 }
 
 // Makes a case-insensitive lookup of the given path and tries to find a handler.
@@ -464,203 +627,100 @@ walk: // outer loop for walking the tree
 // It returns the case-corrected path and a bool indicating whether the lookup
 // was successful.
 func (n *node) findCaseInsensitivePath(path string, fixTrailingSlash bool) (ciPath []byte, found bool) {
-	return n.findCaseInsensitivePathRec(
-		path,
-		make([]byte, 0, len(path)+1), // preallocate enough memory for new path
-		[4]byte{},                    // empty rune buffer
-		fixTrailingSlash,
-	)
-}
+	ciPath = make([]byte, 0, len(path)+1) // preallocate enough memory for new path
+	if ciPath, found = n.findCaseInsensitiveRoot(path, ciPath); found || !fixTrailingSlash {
+		return
+	}
 
-// shift bytes in array by n bytes left
-func shiftNRuneBytes(rb [4]byte, n int) [4]byte {
-	switch n {
-	case 0:
-		return rb
-	case 1:
-		return [4]byte{rb[1], rb[2], rb[3], 0}
-	case 2:
-		return [4]byte{rb[2], rb[3]}
-	case 3:
-		return [4]byte{rb[3]}
-	default:
-		return [4]byte{}
+	// Try to fix the path by adding / removing a trailing slash
+	if len(path) > 1 && path[len(path)-1] == '/' {
+		return n.findCaseInsensitiveRoot(path[:len(path)-1], ciPath[:0])
+	} else if len(path) > 0 {
+		return n.findCaseInsensitiveRoot(path+"/", ciPath[:0])
 	}
+	return
 }
 
-// recursive case-insensitive lookup function used by n.findCaseInsensitivePath
-func (n *node) findCaseInsensitivePathRec(path string, ciPath []byte, rb [4]byte, fixTrailingSlash bool) ([]byte, bool) {
+// findCaseInsensitiveRoot matches the path of the root node n before the
+// recursive lookup of the rest of the path
+func (n *node) findCaseInsensitiveRoot(path string, ciPath []byte) ([]byte, bool) {
 	npLen := len(n.path)
+	if len(path) < npLen || !strings.EqualFold(path[:npLen], n.path) {
+		return ciPath, false
+	}
+	return n.findCaseInsensitivePathRec(path[npLen:], append(ciPath, n.path...))
+}
 
-walk: // outer loop for walking the tree
-	for len(path) >= npLen && (npLen == 0 || strings.EqualFold(path[1:npLen], n.path[1:])) {
-		// add common prefix to result
-
-		oldPath := path
-		path = path[npLen:]
-		ciPath = append(ciPath, n.path...)
-
-		if len(path) > 0 {
-			// If this node does not have a wildcard (param or catchAll) child,
-			// we can just look up the next child node and continue to walk down
-			// the tree
-			if !n.wildChild {
-				// skip rune bytes already processed
-				rb = shiftNRuneBytes(rb, npLen)
-
-				if rb[0] != 0 {
-					// old rune not finished
-					for i := 0; i < len(n.indices); i++ {
-						if n.indices[i] == rb[0] {
-							// continue with child node
-							n = n.children[i]
-							npLen = len(n.path)
-							continue walk
-						}
-					}
-				} else {
-					// process a new rune
-					var rv rune
-
-					// find rune start
-					// runes are up to 4 byte long,
-					// -4 would definitely be another rune
-					var off int
-					for max := min(npLen, 3); off < max; off++ {
-						if i := npLen - off; utf8.RuneStart(oldPath[i]) {
-							// read rune from cached path
-							rv, _ = utf8.DecodeRuneInString(oldPath[i:])
-							break
-						}
-					}
-
-					// calculate lowercase bytes of current rune
-					lo := unicode.ToLower(rv)
-					utf8.EncodeRune(rb[:], lo)
-
-					// skip already processed bytes
-					rb = shiftNRuneBytes(rb, off)
-
-					for i := 0; i < len(n.indices); i++ {
-						// lowercase matches
-						if n.indices[i] == rb[0] {
-							// must use a recursive approach since both the
-							// uppercase byte and the lowercase byte might exist
-							// as an index
-							if out, found := n.children[i].findCaseInsensitivePathRec(
-								path, ciPath, rb, fixTrailingSlash,
-							); found {
-								return out, true
-							}
-							break
-						}
-					}
-
-					// if we found no match, the same for the uppercase rune,
-					// if it differs
-					if up := unicode.ToUpper(rv); up != lo {
-						utf8.EncodeRune(rb[:], up)
-						rb = shiftNRuneBytes(rb, off)
-
-						for i, c := 0, rb[0]; i < len(n.indices); i++ {
-							// uppercase matches
-							if n.indices[i] == c {
-								// continue with child node
-								n = n.children[i]
-								npLen = len(n.path)
-								continue walk
-							}
-						}
-					}
-				}
+// indexFold reports whether c is in indices, ASCII letters are compared
+// case-insensitively.
+func indexFold(indices string, c byte) bool {
+	if strings.IndexByte(indices, c) >= 0 {
+		return true
+	}
+	if 'a' <= c && c <= 'z' {
+		return strings.IndexByte(indices, c-'a'+'A') >= 0
+	}
+	if 'A' <= c && c <= 'Z' {
+		return strings.IndexByte(indices, c-'A'+'a') >= 0
+	}
+	return false
+}
 
-				// Nothing found. We can recommend to redirect to the same URL
-				// without a trailing slash if a leaf exists for that path
-				return ciPath, (fixTrailingSlash && path == "/" && n.handle != nil)
+// recursive case-insensitive lookup function used by n.findCaseInsensitivePath,
+// the static children, the param child and the catchAll child are tried in the
+// same order as in n.find
+func (n *node) findCaseInsensitivePathRec(path string, ciPath []byte) ([]byte, bool) {
+	if len(path) == 0 {
+		return ciPath, n.handlers != nil
+	}
+
+	for _, child := range n.children {
+		npLen := len(child.path)
+		if len(path) >= npLen && strings.EqualFold(path[:npLen], child.path) {
+			// add the path of the node to result
+			if out, found := child.findCaseInsensitivePathRec(
+				path[npLen:], append(ciPath, child.path...),
+			); found {
+				return out, true
 			}
+		}
+	}
 
-			n = n.children[0]
-			switch n.nType {
-			case param:
-				// find param end (either '/' or path end)
-				k := 0
-				for k < len(path) && path[k] != '/' {
-					k++
-				}
+	if child := n.paramChild; child != nil {
+		// find param end (either '/' or path end)
+		k := 0
+		for k < len(path) && path[k] != '/' {
+			k++
+		}
 
-				// add param value to case insensitive path
-				ciPath = append(ciPath, path[:k]...)
-
-				// we need to go deeper!
-				if k < len(path) {
-					if len(n.children) > 0 {
-						// continue with child node
-						n = n.children[0]
-						npLen = len(n.path)
-						path = path[k:]
-						continue
-					}
-
-					// ... but we can't
-					if fixTrailingSlash && len(path) == k+1 {
-						return ciPath, true
-					}
-					return ciPath, false
+		// values ending in the middle of the segment, see n.findChild
+		if child.literalChild {
+			for j := 1; j < k; j++ {
+				if !indexFold(child.indices, path[j]) ||
+					(child.constraint != nil && !child.constraint.match(path[:j])) {
+					continue
 				}
-
-				if n.handle != nil {
-					return ciPath, true
-				} else if fixTrailingSlash && len(n.children) == 1 {
-					// No handle found. Check if a handle for this path + a
-					// trailing slash exists
-					n = n.children[0]
-					if n.path == "/" && n.handle != nil {
-						return append(ciPath, '/'), true
-					}
+				if out, found := child.findCaseInsensitivePathRec(
+					path[j:], append(ciPath, path[:j]...),
+				); found {
+					return out, true
 				}
-				return ciPath, false
-
-			case catchAll:
-				return append(ciPath, path...), true
-
-			default:
-				panic("invalid node type")
-			}
-		} else {
-			// We should have reached the node containing the handle.
-			// Check if this node has a handle registered.
-			if n.handle != nil {
-				return ciPath, true
 			}
+		}
 
-			// No handle found.
-			// Try to fix the path by adding a trailing slash
-			if fixTrailingSlash {
-				for i := 0; i < len(n.indices); i++ {
-					if n.indices[i] == '/' {
-						n = n.children[i]
-						if (len(n.path) == 1 && n.handle != nil) ||
-							(n.nType == catchAll && n.children[0].handle != nil) {
-							return append(ciPath, '/'), true
-						}
-						return ciPath, false
-					}
-				}
+		if k > 0 && (child.constraint == nil || child.constraint.match(path[:k])) {
+			// add param value to case insensitive path
+			if out, found := child.findCaseInsensitivePathRec(
+				path[k:], append(ciPath, path[:k]...),
+			); found {
+				return out, true
 			}
-			return ciPath, false
 		}
 	}
 
-	// Nothing found.
-	// Try to fix the path by adding / removing a trailing slash
-	if fixTrailingSlash {
-		if path == "/" {
-			return ciPath, true
-		}
-		if len(path)+1 == npLen && n.path[len(path)] == '/' &&
-			strings.EqualFold(path[1:], n.path[1:len(path)]) && n.handle != nil {
-			return append(ciPath, n.path...), true
-		}
+	if n.catchAllChild != nil && path[0] == '/' {
+		return append(ciPath, path...), true
 	}
+
 	return ciPath, false
 }
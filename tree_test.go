@@ -0,0 +1,459 @@
+package fastweb
+
+import (
+	"strings"
+	"testing"
+)
+
+// fakeHandler returns a handlers chain which records the route it was
+// registered for in *matched.
+func fakeHandler(matched *string, route string) HandlersChain {
+	return HandlersChain{func(Context) { *matched = route }}
+}
+
+type testRequest struct {
+	path   string
+	route  string // empty if no route should match
+	params Params
+}
+
+func checkRequests(t *testing.T, tree *node, matched *string, requests []testRequest) {
+	t.Helper()
+	for _, request := range requests {
+		handlers, ps, _ := tree.getValue(request.path)
+
+		if handlers == nil {
+			if request.route != "" {
+				t.Errorf("handle mismatch for route '%s': Expected non-nil handle", request.path)
+			}
+			continue
+		}
+		if request.route == "" {
+			t.Errorf("handle mismatch for route '%s': Expected nil handle", request.path)
+			continue
+		}
+
+		handlers[len(handlers)-1](nil)
+		if *matched != request.route {
+			t.Errorf("handle mismatch for route '%s': got '%s', want '%s'", request.path, *matched, request.route)
+		}
+		if len(ps) != len(request.params) {
+			t.Errorf("params mismatch for route '%s': got %v, want %v", request.path, ps, request.params)
+			continue
+		}
+		for i := range ps {
+			if ps[i] != request.params[i] {
+				t.Errorf("params mismatch for route '%s': got %v, want %v", request.path, ps, request.params)
+				break
+			}
+		}
+	}
+}
+
+func catchPanic(f func()) (recv interface{}) {
+	defer func() {
+		recv = recover()
+	}()
+	f()
+	return
+}
+
+func TestTreeConstraints(t *testing.T) {
+	var matched string
+	tree := &node{}
+	routes := [...]string{
+		"/users/:id<[0-9]+>",
+		"/users/:id<[0-9]+>/orders",
+		"/typed/:n:int",
+		"/hex/:h<[0-9a-f]{2,}>/x",
+		"/uuid/:u:uuid",
+		"/files/*filepath",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, fakeHandler(&matched, route))
+	}
+
+	checkRequests(t, tree, &matched, []testRequest{
+		{"/users/42", "/users/:id<[0-9]+>", Params{{"id", "42"}}},
+		{"/users/abc", "", nil},
+		{"/users/42/orders", "/users/:id<[0-9]+>/orders", Params{{"id", "42"}}},
+		{"/users/4a/orders", "", nil},
+		{"/typed/-7", "/typed/:n:int", Params{{"n", "-7"}}},
+		{"/typed/7.5", "", nil},
+		{"/hex/ff/x", "/hex/:h<[0-9a-f]{2,}>/x", Params{{"h", "ff"}}},
+		{"/hex/f/x", "", nil},
+		{"/uuid/123e4567-e89b-12d3-a456-426614174000", "/uuid/:u:uuid", Params{{"u", "123e4567-e89b-12d3-a456-426614174000"}}},
+		{"/uuid/123", "", nil},
+		{"/files/a/b", "/files/*filepath", Params{{"filepath", "/a/b"}}},
+	})
+}
+
+func TestTreeConstraintConflicts(t *testing.T) {
+	tree := &node{}
+	tree.addRoute("/users/:id<[0-9]+>", HandlersChain{func(Context) {}})
+
+	conflicts := [...]string{
+		"/users/:id:int",
+		"/users/:id",
+		"/users/:id<[a-z]+>/x",
+	}
+	for _, route := range conflicts {
+		recv := catchPanic(func() {
+			tree.addRoute(route, HandlersChain{func(Context) {}})
+		})
+		if recv == nil {
+			t.Errorf("no panic for conflicting route '%s'", route)
+		} else if msg, _ := recv.(string); !strings.Contains(msg, "constraint") {
+			t.Errorf("unclear panic for conflicting route '%s': %v", route, recv)
+		}
+	}
+
+	invalid := [...]string{
+		"/a/:id<[0-9+>",
+		"/b/:id<(>",
+		"/c/:id:unknown",
+	}
+	for _, route := range invalid {
+		recv := catchPanic(func() {
+			tree.addRoute(route, HandlersChain{func(Context) {}})
+		})
+		if recv == nil {
+			t.Errorf("no panic for invalid route '%s'", route)
+		}
+	}
+}
+
+func TestTreeWildcard(t *testing.T) {
+	var matched string
+	tree := &node{}
+	routes := [...]string{
+		"/",
+		"/cmd/:tool/:sub",
+		"/cmd/:tool/",
+		"/src/*filepath",
+		"/search/",
+		"/search/:query",
+		"/user_:name",
+		"/user_:name/about",
+		"/files/:dir/*filepath",
+		"/doc/",
+		"/doc/go_faq.html",
+		"/doc/go1.html",
+		"/info/:user/public",
+		"/info/:user/project/:project",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, fakeHandler(&matched, route))
+	}
+
+	checkRequests(t, tree, &matched, []testRequest{
+		{"/", "/", nil},
+		{"/cmd/test/", "/cmd/:tool/", Params{{"tool", "test"}}},
+		{"/cmd/test", "", nil},
+		{"/cmd/test/3", "/cmd/:tool/:sub", Params{{"tool", "test"}, {"sub", "3"}}},
+		{"/src/", "/src/*filepath", Params{{"filepath", "/"}}},
+		{"/src/some/file.png", "/src/*filepath", Params{{"filepath", "/some/file.png"}}},
+		{"/search/", "/search/", nil},
+		{"/search/someth!ng+in+ünìcodé", "/search/:query", Params{{"query", "someth!ng+in+ünìcodé"}}},
+		{"/search/someth!ng+in+ünìcodé/", "", nil},
+		{"/user_gopher", "/user_:name", Params{{"name", "gopher"}}},
+		{"/user_gopher/about", "/user_:name/about", Params{{"name", "gopher"}}},
+		{"/files/js/inc/framework.js", "/files/:dir/*filepath", Params{{"dir", "js"}, {"filepath", "/inc/framework.js"}}},
+		{"/info/gordon/public", "/info/:user/public", Params{{"user", "gordon"}}},
+		{"/info/gordon/project/go", "/info/:user/project/:project", Params{{"user", "gordon"}, {"project", "go"}}},
+	})
+}
+
+func TestTreeStaticAndParam(t *testing.T) {
+	var matched string
+	tree := &node{}
+	routes := [...]string{
+		"/users/new",
+		"/users/:id",
+		"/users/:id/edit",
+		"/users/new/preview",
+		"/users/newest",
+		"/static/favicon.ico",
+		"/static/*filepath",
+		"/:page",
+		"/about",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, fakeHandler(&matched, route))
+	}
+
+	checkRequests(t, tree, &matched, []testRequest{
+		{"/users/new", "/users/new", nil},
+		{"/users/newest", "/users/newest", nil},
+		{"/users/ne", "/users/:id", Params{{"id", "ne"}}},
+		{"/users/42", "/users/:id", Params{{"id", "42"}}},
+		{"/users/new/preview", "/users/new/preview", nil},
+		// the static branch doesn't match, so the walk backtracks into :id
+		{"/users/new/edit", "/users/:id/edit", Params{{"id", "new"}}},
+		{"/users/newer", "/users/:id", Params{{"id", "newer"}}},
+		{"/static/favicon.ico", "/static/favicon.ico", nil},
+		{"/static/favicon.ico/x", "/static/*filepath", Params{{"filepath", "/favicon.ico/x"}}},
+		{"/static/css/app.css", "/static/*filepath", Params{{"filepath", "/css/app.css"}}},
+		{"/about", "/about", nil},
+		{"/contact", "/:page", Params{{"page", "contact"}}},
+		{"/users", "/:page", Params{{"page", "users"}}},
+	})
+}
+
+func TestTreeWildcardConflict(t *testing.T) {
+	tree := &node{}
+	routes := [...]string{
+		"/cmd/:tool/:sub",
+		"/src/*filepath",
+		"/user_:name",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, HandlersChain{func(Context) {}})
+	}
+
+	conflicts := [...]string{
+		"/cmd/:tool/:subcmd",
+		"/cmd/:name/:sub",
+		"/src/*filename",
+		"/user_:id",
+		"/src/*filepath",
+		"/src/*filepath/x",
+		"/src/a*filepath",
+		"/cmd/:tool:sub",
+	}
+	for _, route := range conflicts {
+		if recv := catchPanic(func() {
+			tree.addRoute(route, HandlersChain{func(Context) {}})
+		}); recv == nil {
+			t.Errorf("no panic for conflicting route '%s'", route)
+		}
+	}
+}
+
+func TestTreeTrailingSlashRedirect(t *testing.T) {
+	tree := &node{}
+	routes := [...]string{
+		"/hi",
+		"/b/",
+		"/search/:query",
+		"/cmd/:tool/",
+		"/src/*filepath",
+		"/x",
+		"/x/y",
+		"/y/",
+		"/y/z",
+		"/0/:id",
+		"/0/:id/1",
+		"/1/:id/",
+		"/1/:id/2",
+		"/aa",
+		"/a/",
+		"/admin",
+		"/admin/:category",
+		"/admin/:category/:page",
+		"/doc",
+		"/doc/go_faq.html",
+		"/doc/go1.html",
+		"/no/a",
+		"/no/b",
+		"/api/hello/:name",
+		"/users/new/",
+		"/users/:id",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, HandlersChain{func(Context) {}})
+	}
+
+	tsrRoutes := [...]string{
+		"/hi/",
+		"/b",
+		"/search/gopher/",
+		"/cmd/vet",
+		"/src",
+		"/x/",
+		"/y",
+		"/0/go/",
+		"/1/go",
+		"/a",
+		"/admin/",
+		"/admin/config/",
+		"/admin/config/permissions/",
+		"/doc/",
+		"/users/42/",
+	}
+	for _, route := range tsrRoutes {
+		handlers, _, tsr := tree.getValue(route)
+		if handlers != nil {
+			t.Fatalf("non-nil handler for TSR route '%s", route)
+		} else if !tsr {
+			t.Errorf("expected TSR recommendation for route '%s'", route)
+		}
+	}
+
+	noTsrRoutes := [...]string{
+		"/",
+		"/no",
+		"/no/",
+		"/_",
+		"/_/",
+		"/api/world/abc",
+	}
+	for _, route := range noTsrRoutes {
+		handlers, _, tsr := tree.getValue(route)
+		if handlers != nil {
+			t.Fatalf("non-nil handler for No-TSR route '%s", route)
+		} else if tsr {
+			t.Errorf("expected no TSR recommendation for route '%s'", route)
+		}
+	}
+}
+
+func TestTreeFindCaseInsensitivePath(t *testing.T) {
+	tree := &node{}
+	routes := [...]string{
+		"/hi",
+		"/b/",
+		"/ABC/",
+		"/search/:query",
+		"/cmd/:tool/",
+		"/src/*filepath",
+		"/x",
+		"/x/y",
+		"/y/",
+		"/y/z",
+		"/0/:id",
+		"/0/:id/1",
+		"/users/new",
+		"/users/:id/edit",
+		"/doc/go1.html",
+		"/ÜberPfad",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, HandlersChain{func(Context) {}})
+	}
+
+	tests := []struct {
+		in    string
+		out   string
+		found bool
+		slash bool
+	}{
+		{"/HI", "/hi", true, false},
+		{"/B/", "/b/", true, false},
+		{"/abc/", "/ABC/", true, false},
+		{"/SEARCH/QueryS", "/search/QueryS", true, false},
+		{"/CMD/TooL/", "/cmd/TooL/", true, false},
+		{"/SRC/FILE/PATH", "/src/FILE/PATH", true, false},
+		{"/X/Y", "/x/y", true, false},
+		{"/0/GO/1", "/0/GO/1", true, false},
+		{"/USERS/NEW", "/users/new", true, false},
+		{"/USERS/NEW/EDIT", "/users/NEW/edit", true, false},
+		{"/DOC/GO1.HTML", "/doc/go1.html", true, false},
+		{"/überpfad", "/ÜberPfad", true, false},
+		{"/HI/", "/hi", true, true},
+		{"/B", "/b/", true, true},
+		{"/Y", "/y/", true, true},
+		{"/CMD/TooL", "/cmd/TooL/", true, true},
+		{"/nope", "", false, true},
+	}
+	for _, test := range tests {
+		out, found := tree.findCaseInsensitivePath(test.in, test.slash)
+		if found != test.found || (found && string(out) != test.out) {
+			t.Errorf("wrong result for '%s': got %s, %t; want %s, %t",
+				test.in, string(out), found, test.out, test.found)
+		}
+	}
+
+	// without fixTrailingSlash the paths with a wrong trailing slash must fail
+	for _, test := range tests {
+		if !test.slash {
+			continue
+		}
+		if _, found := tree.findCaseInsensitivePath(test.in, false); found {
+			t.Errorf("found '%s' without fixTrailingSlash", test.in)
+		}
+	}
+}
+
+func TestTreeMultipleParamsInSegment(t *testing.T) {
+	var matched string
+	tree := &node{}
+	routes := [...]string{
+		"/files/:name",
+		"/files/:name.:ext",
+		"/v:major.:minor/status",
+		"/img/:w x :h",
+		"/flights/:from-:to",
+		"/archive/:name<[a-z.]+>.:ext:alpha",
+		"/users/:user-id",
+		"/users/:user-id/files/:file.name",
+		"/orders/:order-id:int/items",
+	}
+	for _, route := range routes {
+		tree.addRoute(route, fakeHandler(&matched, route))
+	}
+
+	checkRequests(t, tree, &matched, []testRequest{
+		{"/files/readme", "/files/:name", Params{{"name", "readme"}}},
+		{"/files/readme.md", "/files/:name.:ext", Params{{"name", "readme"}, {"ext", "md"}}},
+		{"/files/a.tar.gz", "/files/:name.:ext", Params{{"name", "a"}, {"ext", "tar.gz"}}},
+		{"/files/.md", "/files/:name", Params{{"name", ".md"}}},
+		{"/v1.2/status", "/v:major.:minor/status", Params{{"major", "1"}, {"minor", "2"}}},
+		{"/v1/status", "", nil},
+		{"/img/10 x 20", "/img/:w x :h", Params{{"w", "10"}, {"h", "20"}}},
+		{"/flights/LAX-SFO", "/flights/:from-:to", Params{{"from", "LAX"}, {"to", "SFO"}}},
+		// the constraint of :ext forces the walk to try a longer :name
+		{"/archive/a.tar.gz", "/archive/:name<[a-z.]+>.:ext:alpha", Params{{"name", "a.tar"}, {"ext", "gz"}}},
+		{"/archive/a.tar.7z", "", nil},
+		// a single param runs to the end of the segment, so '-' and '.'
+		// are part of its name
+		{"/users/abc", "/users/:user-id", Params{{"user-id", "abc"}}},
+		{"/users/abc/files/a.b", "/users/:user-id/files/:file.name", Params{{"user-id", "abc"}, {"file.name", "a.b"}}},
+		{"/orders/42/items", "/orders/:order-id:int/items", Params{{"order-id", "42"}}},
+		{"/orders/x/items", "", nil},
+	})
+
+	if recv := catchPanic(func() {
+		tree.addRoute("/x/:a<[0-9]+>:b", HandlersChain{func(Context) {}})
+	}); recv == nil {
+		t.Error("no panic for params which are not separated by a literal")
+	}
+
+	ciPath, found := tree.findCaseInsensitivePath("/V1.2/STATUS", false)
+	if !found || string(ciPath) != "/v1.2/status" {
+		t.Errorf("wrong case-insensitive path: %s, %t", ciPath, found)
+	}
+}
+
+// treeBenchRoutes has no static and param children under the same parent, so
+// the benchmark also runs against the httprouter tree this tree derives from.
+var treeBenchRoutes = [...]string{
+	"/",
+	"/about",
+	"/contact",
+	"/users/:id",
+	"/users/:id/profile",
+	"/users/:id/orders/:order",
+	"/static/*filepath",
+}
+
+func BenchmarkTreeGetValue(b *testing.B) {
+	tree := &node{}
+	for _, route := range treeBenchRoutes {
+		tree.addRoute(route, HandlersChain{func(Context) {}})
+	}
+
+	for _, path := range [...]string{
+		"/about",
+		"/users/42",
+		"/users/42/orders/7",
+		"/static/css/app.css",
+	} {
+		b.Run(path, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tree.getValue(path)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package fastweb
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// UploadLimit 上传文件的限制，零值表示不限制
+type UploadLimit struct {
+	MaxFiles     int      // 文件总数
+	MaxFileSize  int64    // 单个文件的字节数
+	AllowedTypes []string // 允许的 MIME 类型，如 "image/png"、"image/*"
+}
+
+// LimitUpload 返回检查 multipart 表单中上传文件的中间件，用于单个路由，如
+// engine.POST("/avatar", fastweb.LimitUpload(fastweb.UploadLimit{MaxFiles: 1, AllowedTypes: []string{"image/*"}}), Avatar)。
+// 文件类型由文件内容检测（http.DetectContentType），不使用客户端声明的 Content-Type。
+// 文件数量或大小超出限制时返回 413，类型不允许时返回 415，表单无法解析时返回 400，
+// 不是 multipart 表单的请求不做检查。
+// 检查发生在 fasthttp 读取并解析完整个请求体之后，请求体大小的上限由 WithMaxRequestBodySize 设置
+func LimitUpload(limit UploadLimit) HandlerFunc {
+	return func(ctx Context) {
+		form, err := ctx.MultipartForm()
+		if err == fasthttp.ErrNoMultipartForm {
+			return
+		}
+		if err != nil {
+			ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+			ctx.Abort()
+			return
+		}
+
+		var count int
+		for _, files := range form.File {
+			count += len(files)
+		}
+		if limit.MaxFiles > 0 && count > limit.MaxFiles {
+			ctx.Error(fmt.Sprintf("too many files: %d > %d", count, limit.MaxFiles), fasthttp.StatusRequestEntityTooLarge)
+			ctx.Abort()
+			return
+		}
+
+		for _, files := range form.File {
+			for _, fh := range files {
+				if limit.MaxFileSize > 0 && fh.Size > limit.MaxFileSize {
+					ctx.Error(fmt.Sprintf("file %q is too large", fh.Filename), fasthttp.StatusRequestEntityTooLarge)
+					ctx.Abort()
+					return
+				}
+				if len(limit.AllowedTypes) == 0 {
+					continue
+				}
+
+				mimeType, err := sniff(fh)
+				if err != nil {
+					ctx.Error(err.Error(), fasthttp.StatusBadRequest)
+					ctx.Abort()
+					return
+				}
+				if !allowedType(limit.AllowedTypes, mimeType) {
+					ctx.Error(fmt.Sprintf("file %q has unsupported type %s", fh.Filename, mimeType), fasthttp.StatusUnsupportedMediaType)
+					ctx.Abort()
+					return
+				}
+			}
+		}
+	}
+}
+
+// sniff 根据文件的前 512 字节检测 MIME 类型，返回值不含参数
+func sniff(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	mimeType := http.DetectContentType(buf[:n])
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	return mimeType, nil
+}
+
+// allowedType 判断 mimeType 是否在 allowed 中，"image/*" 匹配所有 image 类型
+func allowedType(allowed []string, mimeType string) bool {
+	for _, t := range allowed {
+		if t == mimeType || strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package fastweb
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func performUpload(engine *Engine, uri string, files map[string][][]byte) *fasthttp.RequestCtx {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("title", "holiday")
+	for name, contents := range files {
+		for i, content := range contents {
+			fw, _ := w.CreateFormFile(name, name+string(rune('a'+i)))
+			fw.Write(content)
+		}
+	}
+	w.Close()
+
+	fctx := &fasthttp.RequestCtx{}
+	fctx.Request.Header.SetMethod("POST")
+	fctx.Request.SetRequestURI(uri)
+	fctx.Request.Header.SetContentType(w.FormDataContentType())
+	fctx.Request.SetBody(body.Bytes())
+	engine.requestHandler(fctx)
+	return fctx
+}
+
+func TestUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fastweb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type uploadReq struct {
+		Title  string                  `form:"title" valid:",required"`
+		Avatar *multipart.FileHeader   `form:"avatar" valid:",required"`
+		Photos []*multipart.FileHeader `form:"photos"`
+	}
+
+	var req uploadReq
+	engine := New()
+	engine.POST("/upload", func(ctx Context) {
+		req = uploadReq{}
+		if err := ctx.Bind(&req); err != nil {
+			ctx.Error(err.Error(), 400)
+			return
+		}
+		fh, err := ctx.FormFile("avatar")
+		if err != nil || fh != req.Avatar {
+			ctx.Error("FormFile mismatch", 500)
+			return
+		}
+		if err := ctx.SaveUploadedFile(fh, filepath.Join(dir, "avatar.png")); err != nil {
+			ctx.Error(err.Error(), 500)
+		}
+	})
+
+	fctx := performUpload(engine, "/upload", map[string][][]byte{
+		"avatar": {pngHeader},
+		"photos": {[]byte("a"), []byte("b")},
+	})
+	if code := fctx.Response.StatusCode(); code != 200 {
+		t.Fatalf("status = %d: %s", code, fctx.Response.Body())
+	}
+	if req.Title != "holiday" || req.Avatar == nil || len(req.Photos) != 2 {
+		t.Fatalf("unexpected result %+v", req)
+	}
+	if saved, err := ioutil.ReadFile(filepath.Join(dir, "avatar.png")); err != nil || !bytes.Equal(saved, pngHeader) {
+		t.Fatalf("saved file = %q, %v", saved, err)
+	}
+
+	fctx = performUpload(engine, "/upload", map[string][][]byte{"photos": {[]byte("a")}})
+	if body := string(fctx.Response.Body()); body != "Avatar[avatar] is required" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestLimitUpload(t *testing.T) {
+	engine := New()
+	engine.POST("/upload", LimitUpload(UploadLimit{
+		MaxFiles:     2,
+		MaxFileSize:  32,
+		AllowedTypes: []string{"image/*", "text/plain"},
+	}), func(ctx Context) {
+		ctx.SetStatusCode(201)
+	})
+
+	tests := []struct {
+		files map[string][][]byte
+		code  int
+	}{
+		{map[string][][]byte{"a": {pngHeader}, "b": {[]byte("hello")}}, 201},
+		{map[string][][]byte{"a": {pngHeader, pngHeader}, "b": {pngHeader}}, 413},
+		{map[string][][]byte{"a": {bytes.Repeat([]byte("x"), 33)}}, 413},
+		{map[string][][]byte{"a": {[]byte("%PDF-1.4")}}, 415},
+	}
+	for i, tt := range tests {
+		fctx := performUpload(engine, "/upload", tt.files)
+		if code := fctx.Response.StatusCode(); code != tt.code {
+			t.Errorf("#%d: status = %d, want %d: %s", i, code, tt.code, fctx.Response.Body())
+		}
+	}
+
+	if fctx := performRequest(engine, "POST", "/upload"); fctx.Response.StatusCode() != 201 {
+		t.Errorf("non-multipart request: status = %d", fctx.Response.StatusCode())
+	}
+}
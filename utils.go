@@ -1,6 +1,8 @@
 package fastweb
 
 import (
+	"reflect"
+	"runtime"
 	"unsafe"
 )
 
@@ -19,3 +21,8 @@ func s2b(s string) []byte {
 	b := [3]uintptr{x[0], x[1], x[1]}
 	return *(*[]byte)(unsafe.Pointer(&b))
 }
+
+// nameOfFunction returns the full name of the function f, e.g. main.GetUser
+func nameOfFunction(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+}